@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image/gif"
+)
+
+// Returned by compressImage and convertImage for animated images, which
+// would be flattened to their first frame by re-encoding
+var errAnimatedImage = errors.New("can't be re-encoded without losing its animation")
+
+// Name of the format of an animated GIF or WebP image, or "" for still
+// images and other formats
+func animatedFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err == nil && len(anim.Image) > 1 {
+			return "GIF"
+		}
+	case len(data) >= 21 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		// Extended WebP files carry an animation flag in their VP8X header
+		if string(data[12:16]) == "VP8X" && data[20]&0x02 != 0 {
+			return "WebP"
+		}
+	}
+	return ""
+}
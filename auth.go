@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Doer that calls a hook on every request before sending it
+type hookDoer struct {
+	Doer
+	onRequest func(*http.Request) error
+}
+
+func (d *hookDoer) Do(req *http.Request) (*http.Response, error) {
+	if err := d.onRequest(req); err != nil {
+		return nil, fmt.Errorf("request hook: %v", err)
+	}
+	return d.Doer.Do(req)
+}
+
+// Wrap client so hook runs before each request (nil hook = unchanged)
+func withRequestHook(client Doer, hook func(*http.Request) error) Doer {
+	if hook == nil {
+		return client
+	}
+	return &hookDoer{Doer: client, onRequest: hook}
+}
+
+// Authorization header produced by an external command, cached for a while
+// so the command doesn't run for every single image
+type authCommand struct {
+	command string
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	value   string
+	fetched time.Time
+}
+
+// Run the command and return its trimmed output
+func (a *authCommand) run() (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", a.command)
+	} else {
+		cmd = exec.Command("sh", "-c", a.command)
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("auth command failed: %v", err)
+	}
+	value := strings.TrimSpace(string(output))
+	if value == "" {
+		return "", fmt.Errorf("auth command produced no output")
+	}
+	return value, nil
+}
+
+// Request hook setting the Authorization header from the command output
+func (a *authCommand) onRequest(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.value == "" || time.Since(a.fetched) >= a.ttl {
+		value, err := a.run()
+		if err != nil {
+			return err
+		}
+		a.value = value
+		a.fetched = time.Now()
+	}
+
+	req.Header.Set("Authorization", a.value)
+	return nil
+}
+
+// Authorization header values for specific hosts, keyed by lowercase host
+type hostAuth map[string]string
+
+// Parse a -host-auth value of the form "host1=Bearer T1;host2=Basic ...".
+// Errors name the host but never the credentials.
+func parseHostAuth(value string) (hostAuth, error) {
+	auth := make(hostAuth)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		host, credentials, ok := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		credentials = strings.TrimSpace(credentials)
+		if !ok || host == "" {
+			return nil, fmt.Errorf("invalid -host-auth entry, expected host=credentials separated by ;")
+		}
+		if credentials == "" {
+			return nil, fmt.Errorf("invalid -host-auth entry for %s: empty credentials", host)
+		}
+		auth[host] = credentials
+	}
+	return auth, nil
+}
+
+// Credentials for a request URL, matching host:port first, then the host
+// name alone
+func (h hostAuth) lookup(u *url.URL) (string, bool) {
+	if credentials, ok := h[strings.ToLower(u.Host)]; ok {
+		return credentials, true
+	}
+	credentials, ok := h[strings.ToLower(u.Hostname())]
+	return credentials, ok
+}
+
+// Request hook setting the Authorization header of listed hosts. Other
+// requests go to next (nil = none) without the header, so credentials
+// copied onto a mirror request don't leak to the mirror's host.
+func (h hostAuth) hook(next func(*http.Request) error) func(*http.Request) error {
+	return func(req *http.Request) error {
+		if credentials, ok := h.lookup(req.URL); ok {
+			req.Header.Set("Authorization", credentials)
+			return nil
+		}
+		req.Header.Del("Authorization")
+		if next != nil {
+			return next(req)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Sink whose stored files can be read back, to compare their content
+type sinkReader interface {
+	Read(path string) ([]byte, error)
+}
+
+func (s *dirSink) Read(path string) ([]byte, error) {
+	return os.ReadFile(s.Location(path))
+}
+
+// Pick where to save data under RenameExisting. A free outputPath is used as
+// is. If it holds the same image, that path is returned with exists set.
+// Otherwise the data gets a name with a hash of its content, like
+// photo-1a2b3c4d.jpg, so a later run finds it again, numbered if even that
+// is taken by something else.
+func resolveCollision(sink Sink, outputPath string, data []byte) (resolved string, exists bool, err error) {
+	ext := path.Ext(outputPath)
+	stem := strings.TrimSuffix(outputPath, ext)
+	sum := sha1.Sum(data)
+	hash := hex.EncodeToString(sum[:4])
+
+	candidate := outputPath
+	for n := 1; ; n++ {
+		same, taken, err := sameContent(sink, candidate, data)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to check existing file: %v", err)
+		}
+		if !taken || same {
+			return candidate, taken, nil
+		}
+		if n == 1 {
+			candidate = fmt.Sprintf("%s-%s%s", stem, hash, ext)
+		} else {
+			candidate = fmt.Sprintf("%s-%s-%d%s", stem, hash, n, ext)
+		}
+	}
+}
+
+// Check whether a file exists at path and holds data. Sinks that can't be
+// read back are compared by size only.
+func sameContent(sink Sink, path string, data []byte) (same, exists bool, err error) {
+	size, exists, err := sink.Stat(path)
+	if err != nil || !exists {
+		return false, exists, err
+	}
+	if size != int64(len(data)) {
+		return false, true, nil
+	}
+	reader, ok := sink.(sinkReader)
+	if !ok {
+		return true, true, nil
+	}
+	existing, err := reader.Read(path)
+	if err != nil {
+		return false, true, err
+	}
+	return bytes.Equal(existing, data), true, nil
+}
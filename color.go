@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// ANSI colors for status lines, enabled by enableColor
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+var useColor bool
+
+// Turn on colored output when stdout is a terminal, unless disabled with
+// -no-color or the NO_COLOR environment variable (https://no-color.org)
+func enableColor(noColor bool) {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return
+	}
+	info, err := os.Stdout.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return
+	}
+	// The classic Windows console doesn't understand ANSI escapes
+	if runtime.GOOS == "windows" && os.Getenv("WT_SESSION") == "" && os.Getenv("TERM") == "" {
+		return
+	}
+	useColor = true
+}
+
+// Wrap text in a color when colored output is on
+func colorize(color, text string) string {
+	if !useColor {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// Print a warning line, in yellow when colored output is on
+func warnf(format string, args ...interface{}) {
+	fmt.Println(colorize(colorYellow, fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Flags selecting the JSON input and how URLs are extracted from it, shared
+// by the extract and download subcommands
+type inputFlags struct {
+	inlineJSON      string
+	jsonEnv         string
+	pointer         string
+	graphQL         bool
+	lenient         bool
+	extractWorkers  int
+	followRefs      bool
+	followDepth     int
+	maxJSONRefs     int
+	transforms      transformFlag
+	httpsOnly       bool
+	upgradeInsecure bool
+	ignoreQuery     bool
+	where           whereFlag
+	fields          string
+	srcsetPrefer    string
+	maxURLLength    int
+	maxURLsPerValue int
+	parseHTML       bool
+	baseURL         string
+	csvColumn       string
+	cursorField     string
+	cursorParam     string
+	maxPages        int
+	sniff           bool
+	urlArray        bool
+	contentTypes    contentTypeFlag
+}
+
+func (f *inputFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.inlineJSON, "json", "", "Read JSON from the given string instead of a file")
+	fs.StringVar(&f.jsonEnv, "json-env", "", "Read JSON from the named environment variable")
+	fs.StringVar(&f.pointer, "pointer", "", "RFC 6901 JSON Pointer selecting the subtree to scan, e.g. /products/0/images")
+	fs.BoolVar(&f.graphQL, "graphql", false, "Only scan the data of a GraphQL response and report its errors")
+	fs.BoolVar(&f.lenient, "lenient", false, "Accept // and /* */ comments and trailing commas in the input")
+	fs.Var(&f.where, "where", "Only extract from objects whose field matches, as key=value or key!=value (repeatable)")
+	fs.StringVar(&f.fields, "field", "", "Only extract values stored under these comma-separated JSON keys, e.g. imageUrl,thumbnailUrl")
+	fs.StringVar(&f.srcsetPrefer, "srcset-prefer", srcsetAll, "Candidates taken from srcset values: all or largest")
+	fs.StringVar(&f.csvColumn, "csv-column", "", "Only scan this column of .csv inputs, by header name or 1-based number, e.g. url")
+	fs.IntVar(&f.maxURLLength, "max-url-length", 2048, "Skip JSON strings longer than this many characters instead of scanning them for links (0 = no limit)")
+	fs.BoolVar(&f.parseHTML, "parse-html", false, "Also extract images from HTML <img> and <source> tags and Markdown image syntax in string values")
+	fs.StringVar(&f.baseURL, "base-url", "", "Resolve relative links found by -parse-html against this URL (default: the input URL for API inputs)")
+	fs.IntVar(&f.maxURLsPerValue, "max-urls-per-value", 0, "Take at most this many links from a single JSON string, e.g. a large HTML blob (0 = no limit)")
+	fs.StringVar(&f.cursorField, "cursor-field", "", "Field or JSON Pointer holding the next page cursor of API URL inputs, e.g. nextCursor")
+	fs.StringVar(&f.cursorParam, "cursor-param", "", "Query parameter the cursor is sent in to fetch the next page, e.g. after")
+	fs.IntVar(&f.maxPages, "max-pages", 100, "Maximum pages fetched per API URL with -cursor-field")
+	fs.IntVar(&f.extractWorkers, "extract-workers", 1, "Workers used to extract URLs from a top-level JSON array")
+	fs.BoolVar(&f.followRefs, "follow-json-refs", false, "Fetch JSON documents referenced by .json URLs and extract their images too")
+	fs.IntVar(&f.followDepth, "follow-depth", 2, "Maximum depth of followed JSON references")
+	fs.IntVar(&f.maxJSONRefs, "max-json-refs", 100, "Maximum number of referenced JSON documents fetched per input")
+	fs.Var(&f.transforms, "transform-url", "Sed-style substitution applied to each URL, e.g. s/thumb/full/ (repeatable)")
+	fs.BoolVar(&f.httpsOnly, "https-only", false, "Skip image links that use plain http://")
+	fs.BoolVar(&f.upgradeInsecure, "upgrade-insecure", false, "Rewrite plain http:// image links to https://")
+	fs.BoolVar(&f.ignoreQuery, "ignore-query-in-dedup", false, "Treat URLs that differ only in the query string as duplicates")
+	fs.BoolVar(&f.urlArray, "json-array-of-urls", false, "Require each input to be a top-level array of URL strings, each taken as an image link")
+	fs.BoolVar(&f.sniff, "sniff", false, "Fetch the first bytes of extensionless links to find images among them")
+	fs.Var(&f.contentTypes, "extra-content-type", "Treat this Content-Type as an image with this extension, e.g. image/heic=.heic (repeatable)")
+}
+
+func (f *inputFlags) extractOptions() ExtractOptions {
+	eopts := ExtractOptions{
+		Workers:     f.extractWorkers,
+		Pointer:     f.pointer,
+		GraphQL:     f.graphQL,
+		Lenient:     f.lenient,
+		FollowRefs:  f.followRefs,
+		FollowDepth: f.followDepth,
+		MaxRefs:     f.maxJSONRefs,
+		Transforms:  f.transforms,
+
+		HTTPSOnly:       f.httpsOnly,
+		UpgradeInsecure: f.upgradeInsecure,
+
+		IgnoreQueryInDedup: f.ignoreQuery,
+
+		Where:  f.where,
+		Fields: splitList(f.fields),
+
+		SrcsetPrefer:    f.srcsetPrefer,
+		MaxURLLength:    f.maxURLLength,
+		MaxURLsPerValue: f.maxURLsPerValue,
+		ParseHTML:       f.parseHTML,
+		BaseURL:         f.baseURL,
+		CSVColumn:       f.csvColumn,
+
+		CursorField: f.cursorField,
+		CursorParam: f.cursorParam,
+		MaxPages:    f.maxPages,
+
+		URLArray: f.urlArray,
+	}
+	if f.sniff {
+		eopts.Sniff = true
+		eopts.sniffed = newSniffCache()
+	}
+	return eopts
+}
+
+// Split a comma-separated option value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Check option values that flag parsing doesn't
+func (f *inputFlags) validate() error {
+	if f.srcsetPrefer != srcsetAll && f.srcsetPrefer != srcsetLargest {
+		return fmt.Errorf("invalid -srcset-prefer: %s (expected all or largest)", f.srcsetPrefer)
+	}
+	if (f.cursorField == "") != (f.cursorParam == "") {
+		return fmt.Errorf("-cursor-field and -cursor-param must be given together")
+	}
+	if f.maxURLLength < 0 {
+		return fmt.Errorf("invalid -max-url-length: %d (expected 0 or more)", f.maxURLLength)
+	}
+	if f.urlArray && (len(f.where) > 0 || f.fields != "" || f.csvColumn != "") {
+		return fmt.Errorf("-json-array-of-urls can't be combined with -where, -field or -csv-column, which select fields of objects")
+	}
+	if f.baseURL != "" {
+		if !f.parseHTML {
+			return fmt.Errorf("-base-url only applies with -parse-html")
+		}
+		if u, err := url.Parse(f.baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -base-url: %s (expected an absolute http or https URL)", f.baseURL)
+		}
+	}
+	if f.maxURLsPerValue < 0 {
+		return fmt.Errorf("invalid -max-urls-per-value: %d (expected 0 or more)", f.maxURLsPerValue)
+	}
+	if f.maxPages < 1 {
+		return fmt.Errorf("invalid -max-pages: %d (expected 1 or more)", f.maxPages)
+	}
+	return nil
+}
+
+// Input paths given on the command line, or a single empty path for inline
+// and environment JSON
+func (f *inputFlags) inputPaths(args []string) ([]string, error) {
+	if f.inlineJSON != "" || f.jsonEnv != "" {
+		return []string{""}, nil
+	}
+	return expandInputArgs(args)
+}
+
+// Print usage of the extract subcommand
+func printExtractUsage() {
+	fmt.Println("Usage: json-shake extract [options] <json-file-path|csv-file-path|api-url>...")
+	fmt.Println("Print the deduplicated image URLs found in JSON inputs, one per line.")
+	fmt.Println("Options:")
+	fmt.Println("  -config <file>       Load options from a JSON file; command line flags take precedence")
+	fmt.Println("  -o <file>            Write URLs to a file instead of stdout")
+	fmt.Println("  -json <json>         Read JSON from the given string instead of a file")
+	fmt.Println("  -json-env <VARNAME>  Read JSON from an environment variable")
+	fmt.Println("  -pointer <ptr>       Only scan the subtree at this JSON Pointer, e.g. /products/0/images")
+	fmt.Println("  -graphql             Only scan the data of a GraphQL response and report its errors")
+	fmt.Println("  -lenient             Accept // and /* */ comments and trailing commas in the input")
+	fmt.Println("  -where <key=value>   Only extract from objects whose field matches, also key!=value (repeatable)")
+	fmt.Println("  -field <k1,k2>       Only extract values stored under these JSON keys, e.g. imageUrl,thumbnailUrl")
+	fmt.Println("  -srcset-prefer <p>   Candidates taken from srcset values: all or largest (default: all)")
+	fmt.Println("  -max-url-length <n>  Skip strings longer than n characters instead of scanning them (default: 2048, 0 = off)")
+	fmt.Println("  -max-urls-per-value <n>")
+	fmt.Println("                       Take at most n links from a single JSON string (default: 0 = no limit)")
+	fmt.Println("  -parse-html          Also take images from HTML <img>/<source> tags and Markdown ![](url) in strings")
+	fmt.Println("  -base-url <url>      Resolve relative links found by -parse-html against this URL")
+	fmt.Println("  -csv-column <c>      Only scan this column of .csv inputs, by header name or number")
+	fmt.Println("  -https-only          Skip image links that use plain http://")
+	fmt.Println("  -upgrade-insecure    Rewrite plain http:// image links to https://")
+	fmt.Println("  -ignore-query-in-dedup")
+	fmt.Println("                       Treat URLs that differ only in the query string as duplicates")
+	fmt.Println("  -sniff               Fetch the first bytes of extensionless links to find images among them")
+	fmt.Println("  -json-array-of-urls  Require inputs to be a top-level array of URL strings, each taken as an image")
+	fmt.Println("  -extra-content-type <type=.ext>")
+	fmt.Println("                       Treat this Content-Type as an image, e.g. image/heic=.heic (repeatable)")
+	fmt.Println("  -transform-url <s/re/repl/[g]>")
+	fmt.Println("                       Rewrite each extracted URL with a sed-style substitution (repeatable)")
+	fmt.Println("  -cursor-field <f>    Field or JSON Pointer holding the next page cursor of API URL inputs")
+	fmt.Println("  -cursor-param <p>    Query parameter the cursor is sent in for the next page, e.g. after")
+	fmt.Println("  -max-pages <n>       Maximum pages fetched per API URL (default: 100)")
+	fmt.Println("  -extract-workers <n> Workers used to extract URLs from a top-level JSON array (default: 1)")
+	fmt.Println("  -follow-json-refs    Also extract images from JSON documents referenced by .json URLs")
+	fmt.Println("  -follow-depth <n>    Maximum depth of followed JSON references (default: 2)")
+	fmt.Println("  -max-json-refs <n>   Maximum referenced JSON documents fetched per input (default: 100)")
+}
+
+// List the image URLs of JSON inputs without downloading anything
+func runExtract(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	fs.Usage = printExtractUsage
+
+	var in inputFlags
+	var outputPath string
+	in.register(fs)
+	fs.StringVar(&outputPath, "o", "", "Write URLs to this file instead of stdout")
+	parseArgs(fs, args)
+	in.contentTypes.register()
+
+	if fs.NArg() < 1 && in.inlineJSON == "" && in.jsonEnv == "" {
+		printExtractUsage()
+		os.Exit(1)
+	}
+
+	if err := in.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Keep stdout for the URLs
+	if outputPath == "" {
+		statusOut = os.Stderr
+	}
+
+	inputPaths, err := in.inputPaths(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	client := newHTTPClient(nil, nil)
+	eopts := in.extractOptions()
+	var urls []string
+	for _, inputPath := range inputPaths {
+		extracted, err := readImageURLs(client, inputPath, in.inlineJSON, in.jsonEnv, eopts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			if len(inputPaths) == 1 {
+				os.Exit(1)
+			}
+			continue
+		}
+		urls = append(urls, extracted.URLs...)
+	}
+	urls = eopts.dedupe(urls)
+
+	if outputPath == "" {
+		for _, u := range urls {
+			fmt.Println(u)
+		}
+		return
+	}
+	if err := writeURLList(outputPath, urls); err != nil {
+		fmt.Printf("Failed to write URL list: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d URLs to %s\n", len(urls), outputPath)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Print usage of the compress subcommand
+func printCompressUsage() {
+	fmt.Println("Usage: json-shake compress [options] <dir>")
+	fmt.Println("Recompress the images in a directory to a size limit, in place or into another directory.")
+	fmt.Println("Options:")
+	fmt.Println("  -config <file>       Load options from a JSON file; command line flags take precedence")
+	fmt.Println("  -limit <MB>          Maximum image size in MB (required)")
+	fmt.Println("                       Per extension: -limit jpg=1,png=2 or with a default: -limit 1,png=2")
+	fmt.Println("  -jpeg-quality <q>    JPEG quality tried first when compressing (default: 85 down to 25)")
+	fmt.Println("  -post-sharpen        Sharpen images compressed at JPEG quality 45 or below (slower)")
+	fmt.Println("  -compress-formats <f1,f2>")
+	fmt.Println("                       Only compress these formats, e.g. jpg; others are left unchanged (default: all)")
+	fmt.Println("  -o <dir>             Write all images to this directory instead of replacing them")
+}
+
+// Recompress an existing directory of images without downloading anything
+func runCompress(args []string) {
+	flags := flag.NewFlagSet("compress", flag.ExitOnError)
+	flags.Usage = printCompressUsage
+
+	var limits limitFlag
+	var jpegQuality int
+	var postSharpen bool
+	var outputDir string
+	var compressFormats string
+	flags.Var(&limits, "limit", "Maximum image size in MB, optionally per extension like jpg=1,png=2")
+	flags.IntVar(&jpegQuality, "jpeg-quality", 0, "JPEG quality used when compressing, 1-100 (0 = try 85 down to 25)")
+	flags.BoolVar(&postSharpen, "post-sharpen", false, "Sharpen images compressed at JPEG quality 45 or below to reduce blur")
+	flags.StringVar(&compressFormats, "compress-formats", "", "Only compress images in these comma-separated formats, e.g. jpg, and leave others unchanged")
+	flags.StringVar(&outputDir, "o", "", "Write all images to this directory instead of replacing them")
+	parseArgs(flags, args)
+
+	if flags.NArg() != 1 || (limits.defaultMB == 0 && len(limits.byExt) == 0) {
+		printCompressUsage()
+		os.Exit(1)
+	}
+	if jpegQuality < 0 || jpegQuality > 100 {
+		fmt.Printf("Invalid JPEG quality: %d (expected 1-100)\n", jpegQuality)
+		os.Exit(1)
+	}
+
+	formats, err := parseCompressFormats(compressFormats)
+	if err != nil {
+		fmt.Printf("Invalid -compress-formats: %v\n", err)
+		os.Exit(1)
+	}
+
+	dir := flags.Arg(0)
+	opts := Options{LimitMB: limits.defaultMB, ExtLimits: limits.byExt, JPEGQuality: jpegQuality, PostSharpen: postSharpen, CompressFormats: formats}
+	fmt.Printf("Compressing images in: %s\n", dir)
+	if outputDir != "" {
+		fmt.Printf("Output directory: %s\n", outputDir)
+	}
+	fmt.Printf("Image size limit: %s\n", limits.describe())
+
+	compressed, failed := 0, 0
+	var sizeBefore, sizeAfter int64
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Don't recompress the output of this run
+		if d.IsDir() && outputDir != "" && filepath.Clean(path) == filepath.Clean(outputDir) {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !isCompressibleExt(filepath.Ext(path)) {
+			return nil
+		}
+
+		// Mirror the directory structure into the output directory
+		outPath := path
+		if outputDir != "" {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			outPath = filepath.Join(outputDir, rel)
+		}
+
+		before, after, err := compressFile(path, outPath, opts)
+		if err != nil {
+			fmt.Printf("✗ %s: %v\n", path, err)
+			failed++
+			return nil
+		}
+		if after < before {
+			compressed++
+		}
+		sizeBefore += before
+		sizeAfter += after
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nCompression complete! Compressed: %d, Failed: %d\n", compressed, failed)
+	fmt.Printf("Total size: %.2fMB -> %.2fMB (saved %.2fMB)\n",
+		float64(sizeBefore)/1024/1024, float64(sizeAfter)/1024/1024, float64(sizeBefore-sizeAfter)/1024/1024)
+}
+
+// Extensions compressImage can re-encode
+func isCompressibleExt(ext string) bool {
+	switch normalizeExt(ext) {
+	case "jpg", "png", "gif":
+		return true
+	}
+	return false
+}
+
+// Recompress one file to outPath if it exceeds its limit. When outPath is
+// the file itself it is replaced in place; otherwise images within the limit
+// are copied unchanged. PNG and GIF files are re-encoded as JPEG and renamed.
+// Returns the size before and after.
+func compressFile(path, outPath string, opts Options) (int64, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	size := int64(len(data))
+	inPlace := outPath == path
+
+	result := data
+	limitMB := opts.limitFor(data, path)
+	if limitMB > 0 && float64(size) > limitMB*1024*1024 && !opts.compressesFormat(data, path) {
+		warnf("  %s: over the limit, but %s isn't in -compress-formats, keeping it unchanged", path, imageFormat(data, path))
+	} else if limitMB > 0 && float64(size) > limitMB*1024*1024 {
+		compressed, err := compressImage(data, limitMB, opts.JPEGQuality, opts.PostSharpen)
+		if errors.Is(err, errAnimatedImage) {
+			warnf("  %s: %v, keeping it unchanged", path, err)
+		} else if err != nil {
+			return 0, 0, err
+		} else if len(compressed) < len(data) {
+			result = compressed
+		}
+	}
+
+	// Nothing to do for unchanged images in place
+	if inPlace && len(result) == len(data) {
+		return size, size, nil
+	}
+
+	// Compression re-encodes PNG and GIF as JPEG
+	if len(result) < len(data) && isRenamedToJPEG(outPath) {
+		if outPath, err = jpegPath(outPath, result); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return 0, 0, fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(outPath, result, 0644); err != nil {
+		return 0, 0, fmt.Errorf("failed to write file: %v", err)
+	}
+	if inPlace && outPath != path {
+		os.Remove(path)
+	}
+
+	if len(result) < len(data) {
+		fmt.Printf("✓ %s (%.2fMB -> %.2fMB)\n", filepath.Base(outPath),
+			float64(size)/1024/1024, float64(len(result))/1024/1024)
+	}
+	return size, int64(len(result)), nil
+}
+
+// Check whether compression saves the image at p under a new .jpg name,
+// which it does for PNG and GIF
+func isRenamedToJPEG(p string) bool {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// Path a PNG or GIF at p is saved at once compressed to the JPEG data. A
+// different file already named like it is kept, and the image gets a name
+// with a hash of its content instead, as with -rename-existing.
+func jpegPath(p string, data []byte) (string, error) {
+	dir, name := filepath.Split(strings.TrimSuffix(p, filepath.Ext(p)) + ".jpg")
+	resolved, _, err := resolveCollision(newDirSink(dir, fileModes{}), name, data)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, resolved), nil
+}
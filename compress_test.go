@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// PNG of random pixels, which compresses poorly as PNG and well as JPEG
+func noisyPNG(t *testing.T, size int) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Names of the files in dir, sorted
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Compressing a PNG in place renames it to .jpg, but never over another file
+func TestCompressFileKeepsExistingJPEG(t *testing.T) {
+	dir := t.TempDir()
+	existing := []byte("a different image")
+	writeTestFile(t, filepath.Join(dir, "photo.jpg"), existing)
+	writeTestFile(t, filepath.Join(dir, "photo.png"), noisyPNG(t, 200))
+	writeTestFile(t, filepath.Join(dir, "SCAN.PNG"), noisyPNG(t, 200))
+
+	opts := Options{LimitMB: 0.05}
+	for _, name := range []string{"photo.png", "SCAN.PNG"} {
+		p := filepath.Join(dir, name)
+		if before, after, err := compressFile(p, p, opts); err != nil || after >= before {
+			t.Fatalf("compressFile(%s) = %d, %d, %v", name, before, after, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "photo.jpg"))
+	if err != nil || !bytes.Equal(data, existing) {
+		t.Errorf("photo.jpg was overwritten")
+	}
+	names := dirNames(t, dir)
+	if len(names) != 3 || names[0] != "SCAN.jpg" || !strings.HasPrefix(names[1], "photo-") || names[2] != "photo.jpg" {
+		t.Errorf("files = %v, want SCAN.jpg, photo-<hash>.jpg and photo.jpg", names)
+	}
+}
+
+// Recompressing a tree, in place or into -o, keeps the .jpg files next to
+// the PNGs it renames in every subdirectory
+func TestRunCompressTree(t *testing.T) {
+	src := t.TempDir()
+	existing := []byte("a different image")
+	for _, sub := range []string{".", "a", "a/b"} {
+		writeTestFile(t, filepath.Join(src, sub, "photo.jpg"), existing)
+		writeTestFile(t, filepath.Join(src, sub, "photo.png"), noisyPNG(t, 200))
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	runCompress([]string{"-limit", "0.05", "-o", out, src})
+	runCompress([]string{"-limit", "0.05", src})
+
+	for _, root := range []string{out, src} {
+		for _, sub := range []string{".", "a", "a/b"} {
+			dir := filepath.Join(root, sub)
+			data, err := os.ReadFile(filepath.Join(dir, "photo.jpg"))
+			if err != nil || !bytes.Equal(data, existing) {
+				t.Errorf("%s was overwritten", filepath.Join(dir, "photo.jpg"))
+			}
+			var images []string
+			for _, name := range dirNames(t, dir) {
+				if filepath.Ext(name) != "" {
+					images = append(images, name)
+				}
+			}
+			if len(images) != 2 || !strings.HasPrefix(images[0], "photo-") {
+				t.Errorf("files in %s = %v, want photo-<hash>.jpg and photo.jpg", dir, images)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Parse command line arguments, then fill in options that weren't given from
+// the -config file. -version prints the version and exits.
+func parseArgs(fs *flag.FlagSet, args []string) {
+	configPath := fs.String("config", "", "Load options from a JSON file; command line flags take precedence")
+	showVersion := fs.Bool("version", false, "Print the version and supported formats, then exit")
+	fs.Parse(args)
+	if *showVersion {
+		printVersion()
+		os.Exit(0)
+	}
+
+	if *configPath != "" {
+		if err := applyConfig(fs, *configPath); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+// Apply a JSON config file to a flag set. Keys are flag names and values are
+// strings, numbers, booleans or, for repeatable flags, arrays. Flags given on
+// the command line override the file.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Report all unknown keys at once
+	var unknown []string
+	for _, key := range keys {
+		name := strings.TrimLeft(key, "-")
+		if fs.Lookup(name) == nil || name == "config" {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown keys in config %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	for _, key := range keys {
+		name := strings.TrimLeft(key, "-")
+		if explicit[name] {
+			continue
+		}
+
+		values, ok := config[key].([]interface{})
+		if !ok {
+			values = []interface{}{config[key]}
+		} else if !isRepeatableFlag(fs.Lookup(name)) {
+			return fmt.Errorf("config key %q: a list is only allowed for repeatable options", key)
+		}
+
+		for _, value := range values {
+			var text string
+			switch v := value.(type) {
+			case string:
+				text = v
+			case json.Number:
+				text = v.String()
+			case bool:
+				text = fmt.Sprint(v)
+			default:
+				return fmt.Errorf("config key %q: unsupported value %v", key, value)
+			}
+			if err := fs.Set(name, text); err != nil {
+				return fmt.Errorf("config key %q: invalid value %q: %v", key, text, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Whether a flag accumulates values when given several times
+func isRepeatableFlag(f *flag.Flag) bool {
+	switch f.Value.(type) {
+	case *stringListFlag, *transformFlag, *whereFlag, *contentTypeFlag:
+		return true
+	}
+	return false
+}
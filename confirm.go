@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Returned when a run needs -yes to go ahead without a terminal
+var errNeedsConfirmation = errors.New("pass -yes to proceed")
+
+// Ask before downloading more than threshold images. Without a terminal the
+// answer can't be read, so assumeYes (-yes) is required to go ahead.
+func confirmDownload(count, threshold int, assumeYes bool) (bool, error) {
+	if count <= threshold || assumeYes {
+		return true, nil
+	}
+
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, fmt.Errorf("%d images exceed the confirmation threshold of %d and stdin is not a terminal; %w", count, threshold, errNeedsConfirmation)
+	}
+
+	fmt.Printf("About to download %d images (threshold %d). Continue? [y/N] ", count, threshold)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Spacing between contact sheet cells in pixels
+const contactSheetPadding = 4
+
+// Compose thumbnails of the given images into a single grid image.
+// Images that can't be decoded are left out.
+func writeContactSheet(path string, imagePaths []string, columns, cellSize int) error {
+	var thumbs []image.Image
+	for _, imagePath := range imagePaths {
+		file, err := os.Open(imagePath)
+		if err != nil {
+			continue
+		}
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			fmt.Printf("  Contact sheet: skipping %s (%v)\n", filepath.Base(imagePath), err)
+			continue
+		}
+
+		w, h := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), cellSize, cellSize)
+		thumbs = append(thumbs, resizeImage(img, w, h))
+	}
+
+	if len(thumbs) == 0 {
+		return fmt.Errorf("no decodable images")
+	}
+
+	columns = min(columns, len(thumbs))
+	rows := (len(thumbs) + columns - 1) / columns
+	step := cellSize + contactSheetPadding
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*step+contactSheetPadding, rows*step+contactSheetPadding))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	for i, thumb := range thumbs {
+		// Center each thumbnail in its cell
+		size := thumb.Bounds().Size()
+		x := contactSheetPadding + (i%columns)*step + (cellSize-size.X)/2
+		y := contactSheetPadding + (i/columns)*step + (cellSize-size.Y)/2
+		draw.Draw(sheet, image.Rect(x, y, x+size.X, y+size.Y), thumb, thumb.Bounds().Min, draw.Over)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".jpg" || ext == ".jpeg" {
+		err = jpeg.Encode(file, sheet, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(file, sheet)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	fmt.Printf("Contact sheet with %d images written to %s\n", len(thumbs), path)
+	return file.Close()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Content types added with -extra-content-type, mapped to their extensions.
+// They're consulted before contentTypeExtensions for response headers, but
+// not for sniffed data, which the standard library can't detect them in.
+var extraContentTypes = map[string]string{}
+
+// One "type/subtype=.ext" value of -extra-content-type
+type contentTypeMapping struct {
+	contentType string
+	ext         string
+}
+
+// Parse a -extra-content-type value like "image/heic=.heic"; the dot of the
+// extension is optional
+func parseContentTypeMapping(value string) (contentTypeMapping, error) {
+	contentType, ext, ok := strings.Cut(value, "=")
+	contentType = normalizeContentType(contentType)
+	ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+	if !ok || ext == "" {
+		return contentTypeMapping{}, fmt.Errorf("invalid content type %q (expected type/subtype=.ext, e.g. image/heic=.heic)", value)
+	}
+	mediaType, subtype, _ := strings.Cut(contentType, "/")
+	if mediaType == "" || subtype == "" || strings.ContainsAny(contentType, " \t,") {
+		return contentTypeMapping{}, fmt.Errorf("invalid content type %q (expected type/subtype, e.g. image/heic)", contentType)
+	}
+	for _, r := range ext {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return contentTypeMapping{}, fmt.Errorf("invalid extension %q (expected letters and digits, e.g. .heic)", ext)
+		}
+	}
+	return contentTypeMapping{contentType: contentType, ext: "." + ext}, nil
+}
+
+// Value of the repeatable -extra-content-type flag
+type contentTypeFlag []contentTypeMapping
+
+func (f *contentTypeFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	values := make([]string, len(*f))
+	for i, m := range *f {
+		values[i] = m.contentType + "=" + m.ext
+	}
+	return strings.Join(values, ", ")
+}
+
+func (f *contentTypeFlag) Set(value string) error {
+	m, err := parseContentTypeMapping(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, m)
+	return nil
+}
+
+// Make the content types known to the whole run
+func (f contentTypeFlag) register() {
+	for _, m := range f {
+		extraContentTypes[m.contentType] = m.ext
+	}
+}
+
+// Lowercase media type of a Content-Type header, without parameters
+func normalizeContentType(contentType string) string {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// Check whether a Content-Type header is one added with -extra-content-type
+// that isn't built in. Such images can't be decoded, so -strict-validate
+// trusts their Content-Type instead of checking the data.
+func isExtraContentType(contentType string) bool {
+	contentType = normalizeContentType(contentType)
+	_, extra := extraContentTypes[contentType]
+	_, builtin := contentTypeExtensions[contentType]
+	return extra && !builtin
+}
+
+// Check whether ext, like ".jpg", is the extension of a known image content
+// type, including those added with -extra-content-type
+func isImageExtension(ext string) bool {
+	for _, types := range []map[string]string{contentTypeExtensions, extraContentTypes} {
+		for _, known := range types {
+			if sameExtension(ext, known) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Content types of -extra-content-type, sorted
+func sortedExtraContentTypes() []string {
+	contentTypes := make([]string, 0, len(extraContentTypes))
+	for contentType := range extraContentTypes {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+	return contentTypes
+}
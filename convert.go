@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+)
+
+// Target formats of -convert-to, keyed by the accepted names
+var convertFormats = map[string]string{
+	"jpg":  ".jpg",
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+}
+
+// Quality used for JPEG conversion when -jpeg-quality isn't set
+const defaultConvertQuality = 90
+
+// Parse a -convert-to value into the extension of its format
+func parseConvertFormat(s string) (string, error) {
+	ext, ok := convertFormats[strings.ToLower(strings.TrimPrefix(s, "."))]
+	if !ok {
+		return "", fmt.Errorf("unsupported format %q (expected jpg, png or gif)", s)
+	}
+	return ext, nil
+}
+
+// Re-encode an image to the format of ext (".jpg", ".png" or ".gif").
+// Images already in that format are returned unchanged. Animated images
+// are kept as well, returning errAnimatedImage, since only their first
+// frame would survive. Transparency that JPEG can't keep is reported
+// with a warning and flattened onto white.
+func convertImage(data []byte, ext string, quality int) ([]byte, error) {
+	if sniffExtension(data) == ext {
+		return data, nil
+	}
+	if format := animatedFormat(data); format != "" {
+		return nil, fmt.Errorf("animated %s %w", format, errAnimatedImage)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	var buf bytes.Buffer
+	switch ext {
+	case ".jpg":
+		if hasTransparency(img) {
+			warnf("  Warning: JPEG has no transparency, transparent areas become white")
+			img = flattenOnWhite(img)
+		}
+		if quality <= 0 {
+			quality = defaultConvertQuality
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case ".png":
+		err = png.Encode(&buf, img)
+	case ".gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Report whether any pixel of img is not fully opaque
+func hasTransparency(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Composite img onto a white background
+func flattenOnWhite(img image.Image) image.Image {
+	out := image.NewRGBA(img.Bounds())
+	draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Over)
+	return out
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cookie jar that also sends user-supplied cookies to every host.
+// Cookies given with -cookie have no domain, so they are attached to all
+// requests, including redirect targets on other hosts.
+type sessionJar struct {
+	*cookiejar.Jar
+	global []*http.Cookie
+}
+
+func (j *sessionJar) Cookies(u *url.URL) []*http.Cookie {
+	cookies := j.Jar.Cookies(u)
+	for _, c := range j.global {
+		if !hasCookie(cookies, c.Name) {
+			cookies = append(cookies, c)
+		}
+	}
+	return cookies
+}
+
+func hasCookie(cookies []*http.Cookie, name string) bool {
+	for _, c := range cookies {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Build a cookie jar from -cookie values and an optional Netscape cookie file
+func newSessionJar(pairs []string, jarFile string) (*sessionJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	sj := &sessionJar{Jar: jar}
+
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid cookie %q, expected name=value", pair)
+		}
+		sj.global = append(sj.global, &http.Cookie{Name: name, Value: strings.TrimSpace(value)})
+	}
+
+	if jarFile != "" {
+		if err := loadNetscapeCookies(jar, jarFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return sj, nil
+}
+
+// Load cookies from a Netscape/Mozilla cookies.txt file into the jar
+func loadNetscapeCookies(jar *cookiejar.Jar, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// curl marks HttpOnly cookies with a comment-like prefix
+		httpOnly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httpOnly = true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: expected 7 tab-separated fields, got %d", path, lineNum, len(fields))
+		}
+
+		domain := fields[0]
+		includeSubdomains := strings.EqualFold(fields[1], "TRUE")
+		secure := strings.EqualFold(fields[3], "TRUE")
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid expiry %q", path, lineNum, fields[4])
+		}
+
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if includeSubdomains {
+			cookie.Domain = domain
+		}
+		// An expiry of 0 marks a session cookie
+		if expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		host := strings.TrimPrefix(domain, ".")
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: fields[2]}, []*http.Cookie{cookie})
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Check whether an input file is CSV by its extension, also when compressed
+func isCSVInput(path string) bool {
+	name := filepath.Base(path)
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".bz2", ".zst":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return strings.EqualFold(filepath.Ext(name), ".csv")
+}
+
+// Parse CSV data into an array of row objects, so the JSON extraction and
+// its filters apply unchanged. Rows are keyed by the header row, or by the
+// 1-based column number when the first row contains a URL and so can't be a
+// header. column, if set, is resolved to the key of that column by header
+// name or number.
+func parseCSV(data []byte, column string) (rows []interface{}, key string, err error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1 // Allow ragged rows
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var header []string
+	if len(records) > 0 && !csvHasURL(records[0]) {
+		header, records = records[0], records[1:]
+	}
+	if column != "" {
+		key, err = csvColumnKey(header, column)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	for _, record := range records {
+		row := make(map[string]interface{}, len(record))
+		for i, cell := range record {
+			row[keyOfColumn(header, i)] = cell
+		}
+		rows = append(rows, row)
+	}
+	return rows, key, nil
+}
+
+// Check whether any cell of a row looks like a URL
+func csvHasURL(record []string) bool {
+	for _, cell := range record {
+		if strings.Contains(cell, "://") {
+			return true
+		}
+	}
+	return false
+}
+
+// Key of a -csv-column given as header name or 1-based column number
+func csvColumnKey(header []string, column string) (string, error) {
+	for i, name := range header {
+		if name == column {
+			return keyOfColumn(header, i), nil
+		}
+	}
+	if n, err := strconv.Atoi(column); err == nil && n >= 1 {
+		if header != nil && n > len(header) {
+			return "", fmt.Errorf("CSV has %d columns, no column %d", len(header), n)
+		}
+		return keyOfColumn(header, n-1), nil
+	}
+	if header == nil {
+		return "", fmt.Errorf("CSV has no header row, select the column by number instead of %q", column)
+	}
+	return "", fmt.Errorf("CSV has no column %q (columns: %s)", column, strings.Join(header, ", "))
+}
+
+// Row object key of the column at index i
+func keyOfColumn(header []string, i int) string {
+	if i < len(header) && header[i] != "" {
+		return header[i]
+	}
+	return strconv.Itoa(i + 1)
+}
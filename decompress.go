@@ -0,0 +1,58 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Read an input file, decompressing .gz and .bz2 files by extension
+func readInputFile(path string) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".gz" && ext != ".bz2" && ext != ".zst" {
+		return os.ReadFile(path)
+	}
+	if ext == ".zst" {
+		// The standard library has no zstd decoder
+		return nil, fmt.Errorf("zstd-compressed input is not supported, decompress it first (zstd -d %s)", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader
+	if ext == ".gz" {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip input: %v", err)
+		}
+		defer gz.Close()
+		reader = gz
+	} else {
+		reader = bzip2.NewReader(file)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %v", path, err)
+	}
+	return data, nil
+}
+
+// Input name used for the output directory: the filename without its
+// compression and JSON extensions
+func inputName(path string) string {
+	name := filepath.Base(path)
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".bz2", ".zst":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
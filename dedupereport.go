@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+)
+
+// Shape of one input's links, printed by -dedupe-report instead of
+// downloading
+type dedupeReport struct {
+	Input    string         `json:"input"`
+	Found    int            `json:"found"`   // Links found, duplicates included
+	Unique   int            `json:"unique"`  // Links left to download
+	Removed  int            `json:"removed"` // Duplicates and links dropped by -https-only and similar filters
+	ByHost   map[string]int `json:"by_host"`
+	ByFormat map[string]int `json:"by_format"` // By extension of the URL path, "unknown" without one
+
+	// Output files shared by several URLs, of which only the first is saved
+	Collisions    int `json:"naming_collisions"`
+	CollidingURLs int `json:"colliding_urls"` // URLs that would be skipped as existing files
+}
+
+// Count the links of an input and the naming collisions planned for them
+func newDedupeReport(extracted extractedURLs, collisions []pathCollision) dedupeReport {
+	report := dedupeReport{
+		Input:    extracted.Input,
+		Found:    extracted.Found,
+		Unique:   len(extracted.URLs),
+		Removed:  max(extracted.Found-len(extracted.URLs), 0),
+		ByHost:   make(map[string]int),
+		ByFormat: make(map[string]int),
+	}
+	for _, imageURL := range extracted.URLs {
+		host := "unknown"
+		if u, err := url.Parse(imageURL); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		report.ByHost[host]++
+		report.ByFormat[summaryFormat(Result{URL: imageURL})]++
+	}
+	report.Collisions = len(collisions)
+	for _, collision := range collisions {
+		report.CollidingURLs += len(collision.urls) - 1
+	}
+	return report
+}
+
+func (r dedupeReport) print() {
+	fmt.Println("Dedupe report, nothing is downloaded:")
+	fmt.Printf("Found: %d, Unique: %d, Removed: %d (duplicates and filtered)\n", r.Found, r.Unique, r.Removed)
+	printBreakdown("By host", r.ByHost, r.Unique)
+	printBreakdown("By format", r.ByFormat, r.Unique)
+	if r.Collisions > 0 {
+		fmt.Println(colorize(colorYellow, fmt.Sprintf("Naming collisions: %d filenames shared by several URLs, %d URLs would be skipped (see -dry-run)", r.Collisions, r.CollidingURLs)))
+	} else {
+		fmt.Println("Naming collisions: none")
+	}
+}
+
+// Print counts by key, largest first, with their share of total
+func printBreakdown(title string, counts map[string]int, total int) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	fmt.Printf("%s:\n", title)
+	for _, key := range keys {
+		fmt.Printf("  %-30s %6d  %5.1f%%\n", key, counts[key], float64(counts[key])*100/float64(max(total, 1)))
+	}
+}
+
+// Write the reports of all inputs as an indented JSON array
+func writeDedupeReports(filePath string, reports []dedupeReport) error {
+	if reports == nil {
+		reports = []dedupeReport{}
+	}
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, append(data, '\n'), 0644)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Build a resolver for -dns-server or -doh-url, or nil to use the system
+// resolver
+func newResolver(dnsServer, dohURL string) (*net.Resolver, error) {
+	switch {
+	case dnsServer != "" && dohURL != "":
+		return nil, fmt.Errorf("-dns-server and -doh-url cannot be used together")
+	case dnsServer != "":
+		server := dnsServer
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		dialer := &net.Dialer{Timeout: 5 * time.Second}
+		return &net.Resolver{
+			PreferGo: true,
+			// Send every query to the configured server
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+		}, nil
+	case dohURL != "":
+		u, err := url.Parse(dohURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("invalid DoH URL: %s (expected https://host/path)", dohURL)
+		}
+		// The DoH server itself is resolved with the system resolver
+		client := &http.Client{Timeout: 10 * time.Second}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return &dohConn{ctx: ctx, client: client, url: dohURL}, nil
+			},
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Connection handed to the Go resolver that sends each DNS query as an
+// RFC 8484 POST request. It is not a net.PacketConn, so the resolver uses
+// TCP framing: every message is prefixed with its 2-byte length.
+type dohConn struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	out    bytes.Buffer // Framed queries written by the resolver
+	in     bytes.Buffer // Framed responses read by the resolver
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.out.Write(b)
+
+	// Send every complete query
+	for c.out.Len() >= 2 {
+		size := int(binary.BigEndian.Uint16(c.out.Bytes()[:2]))
+		if c.out.Len() < 2+size {
+			break
+		}
+		c.out.Next(2)
+		query := append([]byte(nil), c.out.Next(size)...)
+
+		answer, err := c.exchange(query)
+		if err != nil {
+			return 0, err
+		}
+		var prefix [2]byte
+		binary.BigEndian.PutUint16(prefix[:], uint16(len(answer)))
+		c.in.Write(prefix[:])
+		c.in.Write(answer)
+	}
+	return len(b), nil
+}
+
+func (c *dohConn) exchange(query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DoH request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned status code: %d", resp.StatusCode)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DoH response: %v", err)
+	}
+	return answer, nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.in.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.in.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr{} }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dohAddr struct{}
+
+func (dohAddr) Network() string { return "doh" }
+func (dohAddr) String() string  { return "doh" }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// URLs planned to be saved to the same output file
+type pathCollision struct {
+	path string
+	urls []string
+}
+
+// Work out where each image would be saved, calling fn (if not nil) with
+// the output path or parse error of each URL in order. Returns the paths
+// shared by several URLs, largest groups first. Paths are compared
+// case-insensitively, since the macOS and Windows file systems are. Indices
+// are padded to width digits, names from -name-field replace the URL path
+// names and dirOf gives the shard and group subdirectory of each image.
+// prefix is prepended to index-based names.
+func planOutputPaths(imageURLs []string, names map[string]string, dirOf func(imageURL string, index int) string, layout, prefix string, width int, fn func(index int, imageURL, outputPath string, err error)) []pathCollision {
+	groups := make(map[string]*pathCollision)
+	var order []*pathCollision
+	for i, imageURL := range imageURLs {
+		parsedURL, err := url.Parse(imageURL)
+		if err != nil {
+			if fn != nil {
+				fn(i+1, imageURL, "", err)
+			}
+			continue
+		}
+		filename := defaultFilename(parsedURL, prefix, i+1, width)
+		if name := names[imageURL]; name != "" {
+			filename, _ = dataFilename(name, parsedURL)
+		}
+		outputPath := outputPathFor(layout, dirOf(imageURL, i+1), parsedURL, filename)
+		if fn != nil {
+			fn(i+1, imageURL, outputPath, nil)
+		}
+
+		key := strings.ToLower(outputPath)
+		group, ok := groups[key]
+		if !ok {
+			group = &pathCollision{path: outputPath}
+			groups[key] = group
+			order = append(order, group)
+		}
+		group.urls = append(group.urls, imageURL)
+	}
+
+	var collisions []pathCollision
+	for _, group := range order {
+		if len(group.urls) > 1 {
+			collisions = append(collisions, *group)
+		}
+	}
+	sort.SliceStable(collisions, func(i, j int) bool {
+		return len(collisions[i].urls) > len(collisions[j].urls)
+	})
+	return collisions
+}
+
+// Print where each image would be saved without downloading anything, and
+// warn about distinct URLs that map to the same output file. Returns the
+// number of colliding groups. The arguments are those of planOutputPaths.
+func printDryRun(imageURLs []string, names map[string]string, dirOf func(imageURL string, index int) string, layout, prefix string, width int) int {
+	fmt.Println("Dry run, nothing is downloaded:")
+	collisions := planOutputPaths(imageURLs, names, dirOf, layout, prefix, width, func(index int, imageURL, outputPath string, err error) {
+		if err != nil {
+			fmt.Printf("[%d/%d] %s -> invalid URL: %v\n", index, len(imageURLs), imageURL, err)
+			return
+		}
+		fmt.Printf("[%d/%d] %s -> %s\n", index, len(imageURLs), imageURL, outputPath)
+	})
+	if len(collisions) == 0 {
+		return 0
+	}
+
+	fmt.Printf("\nWarning: %d output filenames are shared by several URLs; only the first URL of each is saved, the rest are skipped as existing files:\n", len(collisions))
+	for _, collision := range collisions {
+		fmt.Printf("  %s (%d URLs)\n", collision.path, len(collision.urls))
+		for _, imageURL := range collision.urls {
+			fmt.Printf("    %s\n", imageURL)
+		}
+	}
+	if layout == layoutFlat {
+		fmt.Println("Try -output-layout by-host if the URLs come from different hosts.")
+	}
+	return len(collisions)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	estimateWorkers  = 8                     // Concurrent HEAD requests of -estimate
+	estimateInterval = 50 * time.Millisecond // Minimum gap between requests, 20 per second
+)
+
+// Estimated download size of the images of one host, or of all of them
+type sizeEstimate struct {
+	host    string
+	bytes   int64 // Sum of the reported Content-Length values
+	images  int   // Images with a reported size
+	unknown int   // Images whose response had no Content-Length
+	failed  int   // Images whose HEAD request failed
+}
+
+func (e *sizeEstimate) add(check headCheck) {
+	switch {
+	case !check.alive():
+		e.failed++
+	case check.ContentLength < 0:
+		e.unknown++
+	default:
+		e.bytes += check.ContentLength
+		e.images++
+	}
+}
+
+// Describe the estimate, e.g. "12.34MB for 80 images, 5 of unknown size"
+func (e sizeEstimate) describe() string {
+	s := fmt.Sprintf("%.2fMB for %d images", float64(e.bytes)/1024/1024, e.images)
+	if e.unknown > 0 {
+		s += fmt.Sprintf(", %d of unknown size", e.unknown)
+	}
+	if e.failed > 0 {
+		s += fmt.Sprintf(", %d failed", e.failed)
+	}
+	return s
+}
+
+// Send HEAD requests for all URLs, a few at a time and rate-limited, and
+// print the total reported size with a per-host breakdown
+func estimateSizes(client Doer, imageURLs []string, opts Options) {
+	fmt.Printf("Estimating the download size of %d images with HEAD requests...\n", len(imageURLs))
+
+	checks := make([]headCheck, len(imageURLs))
+	budget := newRetryBudget(opts.MaxRetriesPerHost)
+	ticker := time.NewTicker(estimateInterval)
+	defer ticker.Stop()
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(estimateWorkers, len(imageURLs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				<-ticker.C
+				checks[i] = headImage(client, imageURLs[i], opts, budget)
+			}
+		}()
+	}
+	for i := range imageURLs {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	var total sizeEstimate
+	byHost := make(map[string]*sizeEstimate)
+	for i, check := range checks {
+		host := "unknown"
+		if parsedURL, err := url.Parse(imageURLs[i]); err == nil && parsedURL.Host != "" {
+			host = parsedURL.Host
+		}
+		if byHost[host] == nil {
+			byHost[host] = &sizeEstimate{host: host}
+		}
+		byHost[host].add(check)
+		total.add(check)
+	}
+
+	hosts := make([]*sizeEstimate, 0, len(byHost))
+	for _, e := range byHost {
+		hosts = append(hosts, e)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].bytes != hosts[j].bytes {
+			return hosts[i].bytes > hosts[j].bytes
+		}
+		return hosts[i].host < hosts[j].host
+	})
+
+	fmt.Printf("Estimated total: %s\n", total.describe())
+	for _, e := range hosts {
+		fmt.Printf("  %s: %s\n", e.host, e.describe())
+	}
+	if total.unknown > 0 {
+		fmt.Println("Hosts that don't report Content-Length make the actual total larger")
+	}
+}
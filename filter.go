@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Condition on a sibling field given to -where, e.g. status=published
+type whereCond struct {
+	key    string
+	value  string
+	negate bool // key!=value
+}
+
+func parseWhere(expr string) (whereCond, error) {
+	if key, value, ok := strings.Cut(expr, "!="); ok && key != "" {
+		return whereCond{key: key, value: value, negate: true}, nil
+	}
+	if key, value, ok := strings.Cut(expr, "="); ok && key != "" {
+		return whereCond{key: key, value: value}, nil
+	}
+	return whereCond{}, fmt.Errorf("invalid condition %q (expected key=value or key!=value)", expr)
+}
+
+func (c whereCond) String() string {
+	if c.negate {
+		return c.key + "!=" + c.value
+	}
+	return c.key + "=" + c.value
+}
+
+// Value of the repeatable -where flag
+type whereFlag []whereCond
+
+func (f *whereFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	conds := make([]string, len(*f))
+	for i, c := range *f {
+		conds[i] = c.String()
+	}
+	return strings.Join(conds, ", ")
+}
+
+func (f *whereFlag) Set(value string) error {
+	c, err := parseWhere(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, c)
+	return nil
+}
+
+// Restricts which JSON values are scanned for image links. A nil filter
+// scans everything.
+type urlFilter struct {
+	where  []whereCond
+	fields map[string]bool // Only scan values under these keys (nil = all)
+
+	srcsetLargest bool // Only keep the largest candidate of srcset values
+
+	// Strings longer than this aren't scanned for links (0 = no limit)
+	maxURLLength int
+	tooLong      atomic.Int64 // Strings skipped for their length
+
+	// Links taken from one string, so a huge blob can't dominate (0 = no limit)
+	maxURLsPerValue int
+	capped          atomic.Int64 // Strings with links beyond the limit
+
+	// Also take links from HTML image tags and Markdown images in strings,
+	// resolving relative ones against baseURL
+	parseHTML bool
+	baseURL   *url.URL
+	relative  atomic.Int64 // Relative links left out for lack of a base URL
+
+	// Decides whether extensionless links without image keywords are
+	// images (nil = skip them)
+	sniff func(url string) bool
+
+	// Links are named after this field of their nearest enclosing object
+	nameField string
+	names     map[string]string // URL -> name, the first one found wins
+
+	// Links are grouped into subdirectories by this field of their nearest
+	// enclosing object
+	groupField string
+	groups     map[string]string // URL -> group, the first one found wins
+
+	labelsMu sync.Mutex // Guards names and groups
+}
+
+// Wrap fn to record label in labels for the links it receives
+func (f *urlFilter) labelLinks(labels map[string]string, label string, fn func(url string)) func(url string) {
+	return func(u string) {
+		f.labelsMu.Lock()
+		if _, ok := labels[u]; !ok {
+			labels[u] = label
+		}
+		f.labelsMu.Unlock()
+		fn(u)
+	}
+}
+
+// How an object relates to the -where conditions
+type whereMatch int
+
+const (
+	whereNeutral whereMatch = iota // Doesn't have all condition fields
+	wherePass                      // Has all fields and satisfies every condition
+	whereFail                      // A present field doesn't satisfy its condition
+)
+
+func (f *urlFilter) match(obj map[string]interface{}) whereMatch {
+	present := 0
+	for _, c := range f.where {
+		value, ok := obj[c.key]
+		if !ok {
+			continue
+		}
+		present++
+		if (jsonScalarString(value) == c.value) == c.negate {
+			return whereFail
+		}
+	}
+	if present == len(f.where) {
+		return wherePass
+	}
+	return whereNeutral
+}
+
+// Text of a JSON scalar as written in a -where condition
+func jsonScalarString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Traverse JSON and call fn for each image link the filter allows. key is the
+// object key the value is stored under; array elements inherit the key of
+// the array. With -where conditions, objects failing a condition are skipped
+// entirely and links are only collected below an object satisfying all of
+// them.
+func (f *urlFilter) walk(data interface{}, key string, matched bool, fn func(url string)) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if f != nil && len(f.where) > 0 {
+			switch f.match(v) {
+			case whereFail:
+				return
+			case wherePass:
+				matched = true
+			}
+		}
+		if f != nil && f.nameField != "" {
+			switch name := v[f.nameField].(type) {
+			case string, float64:
+				// Inner objects are entered later, so their names win
+				fn = f.labelLinks(f.names, jsonScalarString(name), fn)
+			}
+		}
+		if f != nil && f.groupField != "" {
+			switch group := v[f.groupField].(type) {
+			case string, float64:
+				fn = f.labelLinks(f.groups, jsonScalarString(group), fn)
+			}
+		}
+		// Traverse JSON object
+		for k, value := range v {
+			f.walk(value, k, matched, fn)
+		}
+	case []interface{}:
+		// Traverse JSON array
+		for _, item := range v {
+			f.walk(item, key, matched, fn)
+		}
+	case string:
+		if f != nil && len(f.where) > 0 && !matched {
+			return
+		}
+		if f != nil && f.fields != nil && !f.fields[key] {
+			return
+		}
+		if candidates, ok := parseSrcset(v); ok {
+			// Responsive image list
+			if f != nil && f.srcsetLargest {
+				candidates = []srcsetCandidate{largestSrcsetCandidate(candidates)}
+			}
+			if f != nil && f.maxURLsPerValue > 0 && len(candidates) > f.maxURLsPerValue {
+				candidates = candidates[:f.maxURLsPerValue]
+				f.capped.Add(1)
+			}
+			for _, c := range candidates {
+				fn(c.url)
+			}
+			return
+		}
+		// Rich-text fields are parsed whatever their length, together with
+		// the absolute links in their text
+		if f != nil && f.parseHTML {
+			urls, relative := richTextImageURLs(v, f.baseURL)
+			f.relative.Add(int64(relative))
+			if len(urls) > 0 {
+				urls = dedupeURLs(append(urls, imageURLPattern.FindAllString(v, -1)...))
+				if f.maxURLsPerValue > 0 && len(urls) > f.maxURLsPerValue {
+					urls = urls[:f.maxURLsPerValue]
+					f.capped.Add(1)
+				}
+				for _, u := range urls {
+					fn(u)
+				}
+				return
+			}
+		}
+		// Real links are short; long text blobs would only be slow to scan
+		if f != nil && f.maxURLLength > 0 && len(v) > f.maxURLLength {
+			f.tooLong.Add(1)
+			return
+		}
+		found, limit := false, 0
+		if f != nil {
+			limit = f.maxURLsPerValue
+		}
+		if matchImageURLs(v, limit, func(u string) {
+			found = true
+			fn(u)
+		}) {
+			f.capped.Add(1)
+		}
+		if !found && f != nil && f.sniff != nil && isSniffCandidate(v) && f.sniff(v) {
+			fn(v)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Select the data of a GraphQL response and report its errors, which would
+// otherwise be scanned as content
+func graphQLData(doc interface{}) (interface{}, error) {
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not a GraphQL response: expected an object with a data field")
+	}
+
+	if errs, ok := obj["errors"].([]interface{}); ok && len(errs) > 0 {
+		fmt.Fprintf(statusOut, "GraphQL response has %d errors:\n", len(errs))
+		for _, e := range errs {
+			fmt.Fprintf(statusOut, "  %s\n", describeGraphQLError(e))
+		}
+	}
+
+	data, ok := obj["data"]
+	if !ok || data == nil {
+		return nil, fmt.Errorf("GraphQL response has no data")
+	}
+	return data, nil
+}
+
+// Message of a GraphQL error entry with its path, e.g. "Not found (at products.0.image)"
+func describeGraphQLError(e interface{}) string {
+	entry, ok := e.(map[string]interface{})
+	if !ok {
+		return fmt.Sprint(e)
+	}
+	msg, _ := entry["message"].(string)
+	if msg == "" {
+		msg = "unknown error"
+	}
+	if path, ok := entry["path"].([]interface{}); ok && len(path) > 0 {
+		parts := make([]string, len(path))
+		for i, p := range path {
+			parts[i] = jsonScalarString(p)
+		}
+		msg += " (at " + strings.Join(parts, ".") + ")"
+	}
+	return msg
+}
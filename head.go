@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Response headers of an image checked with a HEAD request
+type headCheck struct {
+	StatusCode    int
+	ContentType   string
+	ContentLength int64 // -1 when unknown
+	LastModified  string
+	Err           error // Request error, or HTTP error for non-2xx statuses
+}
+
+func (c headCheck) alive() bool {
+	return c.Err == nil
+}
+
+// Send a HEAD request for an image without downloading its body
+func headImage(client Doer, imageURL string, opts Options, budget *retryBudget) headCheck {
+	req, err := http.NewRequest(http.MethodHead, imageURL, nil)
+	if err != nil {
+		return headCheck{ContentLength: -1, Err: fmt.Errorf("invalid request: %v", err)}
+	}
+	if opts.HostHeader != "" {
+		req.Host = opts.HostHeader
+	}
+
+	var mirrors []string
+	if parsedURL, err := url.Parse(imageURL); err == nil {
+		mirrors = opts.Mirrors[parsedURL.Host]
+	}
+	resp, err := sendWithRetry(client, req, opts.retryPolicy(), mirrors, budget)
+	if err != nil {
+		return headCheck{ContentLength: -1, Err: fmt.Errorf("request failed: %v", err)}
+	}
+	resp.Body.Close()
+
+	check := headCheck{
+		StatusCode:    resp.StatusCode,
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+		LastModified:  resp.Header.Get("Last-Modified"),
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		check.Err = fmt.Errorf("HTTP error: %s", resp.Status)
+	}
+	return check
+}
+
+// Check every URL with HEAD requests and record the results in the manifest,
+// without writing any image files. Returns the number of alive URLs.
+func checkImageHeads(client Doer, imageURLs []string, opts Options, manifest *manifestWriter) int {
+	alive := 0
+	var totalBytes int64
+	budget := newRetryBudget(opts.MaxRetriesPerHost)
+	for i, imageURL := range imageURLs {
+		check := headImage(client, imageURL, opts, budget)
+		manifest.recordHead(imageURL, check)
+
+		if !check.alive() {
+			fmt.Printf("[%d/%d] %s -> %s\n", i+1, len(imageURLs), imageURL, colorize(colorRed, check.Err.Error()))
+			continue
+		}
+		alive++
+		size := "unknown size"
+		if check.ContentLength >= 0 {
+			size = fmt.Sprintf("%.2fKB", float64(check.ContentLength)/1024)
+			totalBytes += check.ContentLength
+		}
+		fmt.Printf("[%d/%d] %s -> %d %s, %s\n", i+1, len(imageURLs), imageURL, check.StatusCode, check.ContentType, size)
+	}
+
+	fmt.Printf("\nChecked %d URLs: %d alive, %d failed, %.2fMB reported\n",
+		len(imageURLs), alive, len(imageURLs)-alive, float64(totalBytes)/1024/1024)
+	return alive
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// Resize an image to width x height. Each destination pixel is the average
+// of the source pixels it covers, which gives smooth results when shrinking.
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 || width == 0 || height == 0 {
+		return dst
+	}
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := bounds.Min.Y + (y+1)*srcH/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := bounds.Min.X + (x+1)*srcW/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+// Compute the size of an image scaled to fit within maxW x maxH,
+// preserving its aspect ratio
+func fitSize(width, height, maxW, maxH int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	if width*maxH > height*maxW {
+		return maxW, max(1, height*maxW/width)
+	}
+	return max(1, width*maxH/height), maxH
+}
+
+// Sharpen an image with an unsharp mask: each pixel is pushed away from its
+// 3x3 Gaussian-blurred neighborhood by amount (0.5 is mild). The alpha
+// channel is kept.
+func unsharpMask(src image.Image, amount float64) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+	kernel := [3][3]uint32{{1, 2, 1}, {2, 4, 2}, {1, 2, 1}} // Sums to 16
+
+	clamp := func(v float64) uint16 {
+		return uint16(max(0, min(0xffff, v)))
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var br, bg, bb uint32
+			for ky := -1; ky <= 1; ky++ {
+				for kx := -1; kx <= 1; kx++ {
+					// Repeat edge pixels beyond the border
+					sx := min(max(x+kx, bounds.Min.X), bounds.Max.X-1)
+					sy := min(max(y+ky, bounds.Min.Y), bounds.Max.Y-1)
+					r, g, b, _ := src.At(sx, sy).RGBA()
+					w := kernel[ky+1][kx+1]
+					br += r * w
+					bg += g * w
+					bb += b * w
+				}
+			}
+
+			r, g, b, a := src.At(x, y).RGBA()
+			// Premultiplied colors can't exceed alpha
+			limit := float64(a)
+			sharpen := func(v, blurred uint32) uint16 {
+				return min(clamp(float64(v)+amount*(float64(v)-float64(blurred/16))), uint16(limit))
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: sharpen(r, br),
+				G: sharpen(g, bg),
+				B: sharpen(b, bb),
+				A: uint16(a),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parse a JSON document. A UTF-8 byte order mark is ignored, and input made
+// of several whitespace-separated values (e.g. newline-delimited JSON) is
+// parsed as an array of those values. Errors point at the offending line.
+func parseJSON(data []byte) (interface{}, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err == nil {
+		return value, nil
+	}
+
+	// Lenient fallback for concatenated and line-delimited JSON
+	values, streamErr := decodeJSONValues(data)
+	if streamErr == nil && len(values) > 1 {
+		fmt.Fprintf(statusOut, "Input looks like newline-delimited JSON, parsed %d values\n", len(values))
+		return values, nil
+	}
+
+	// The stream error points at the malformed line instead of the start of
+	// the second value
+	if streamErr != nil && looksLineDelimited(data) {
+		err = streamErr
+	}
+	return nil, describeJSONError(data, err)
+}
+
+// Decode a sequence of JSON values
+func decodeJSONValues(data []byte) ([]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var values []interface{}
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
+
+// Turn a JSON error into a message with the line, column and surrounding text
+func describeJSONError(data []byte, err error) error {
+	if bytes.HasPrefix(data, []byte("\xff\xfe")) || bytes.HasPrefix(data, []byte("\xfe\xff")) {
+		return fmt.Errorf("input looks like UTF-16 text; convert it to UTF-8 first")
+	}
+
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+
+	line, column, snippet, caret := jsonErrorContext(data, offset)
+	msg := fmt.Sprintf("%v at line %d, column %d (byte %d)\n  %s\n  %s^", err, line, column, offset, snippet, strings.Repeat(" ", caret))
+	if !utf8.Valid(data) {
+		msg += "\n  Note: the input is not valid UTF-8"
+	}
+	if looksLineDelimited(data) {
+		msg += "\n  Note: the input has one JSON value per line; check for a malformed line"
+	}
+	return errors.New(msg)
+}
+
+// Line and column of a byte offset, and the text around it on that line with
+// the caret position of the offset inside the snippet
+func jsonErrorContext(data []byte, offset int64) (line, column int, snippet string, caret int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	// The decoder reports the offset after the offending byte
+	pos := int(offset)
+	if pos > 0 {
+		pos--
+	}
+
+	line = 1 + bytes.Count(data[:pos], []byte("\n"))
+	lineStart := bytes.LastIndexByte(data[:pos], '\n') + 1
+	lineEnd := len(data)
+	if i := bytes.IndexByte(data[pos:], '\n'); i >= 0 {
+		lineEnd = pos + i
+	}
+	column = pos - lineStart + 1
+
+	// Keep 40 bytes of context on each side for very long lines
+	const context = 40
+	start, end := lineStart, lineEnd
+	prefix, suffix := "", ""
+	if pos-start > context {
+		start = pos - context
+		prefix = "..."
+	}
+	if end-pos > context {
+		end = pos + context
+		suffix = "..."
+	}
+
+	text := strings.ToValidUTF8(strings.ReplaceAll(string(data[start:end]), "\t", " "), "?")
+	snippet = prefix + text + suffix
+	caret = len(prefix) + utf8.RuneCountInString(strings.ToValidUTF8(string(data[start:pos]), "?"))
+	return line, column, snippet, caret
+}
+
+// Whether most non-empty lines start a new JSON object or array
+func looksLineDelimited(data []byte) bool {
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) < 2 {
+		return false
+	}
+	starts := 0
+	for _, l := range lines {
+		l = bytes.TrimSpace(l)
+		if len(l) > 0 && (l[0] == '{' || l[0] == '[') && (l[len(l)-1] == '}' || l[len(l)-1] == ']') {
+			starts++
+		}
+	}
+	return starts*2 > len(lines)
+}
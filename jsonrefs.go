@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Check whether a string is a URL pointing at another JSON document
+func isJSONRef(s string) bool {
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		return false
+	}
+	parsedURL, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(strings.ToLower(parsedURL.Path), ".json")
+}
+
+// Recursively collect URLs of referenced JSON documents
+func collectJSONRefs(data interface{}, refs *[]string) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for _, value := range v {
+			collectJSONRefs(value, refs)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectJSONRefs(item, refs)
+		}
+	case string:
+		if isJSONRef(v) {
+			*refs = append(*refs, v)
+		}
+	}
+}
+
+// Download and parse a referenced JSON document
+func fetchJSON(client Doer, jsonURL string) (interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, jsonURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("not a JSON document (%s): %v", resp.Header.Get("Content-Type"), err)
+	}
+	return data, nil
+}
+
+// Follow JSON document references found in data, breadth first, and return
+// the image links found in the referenced documents. Each document is
+// fetched at most once, references are followed up to maxDepth levels deep
+// and at most maxRefs documents are fetched in total.
+func followJSONRefs(client Doer, data interface{}, maxDepth, maxRefs int, filter *urlFilter) []string {
+	var imageURLs []string
+	visited := make(map[string]bool)
+	fetched := 0
+
+	var refs []string
+	collectJSONRefs(data, &refs)
+
+	for depth := 1; depth <= maxDepth && len(refs) > 0; depth++ {
+		var next []string
+		for _, ref := range refs {
+			if visited[ref] {
+				continue
+			}
+			visited[ref] = true
+
+			if fetched >= maxRefs {
+				fmt.Fprintf(statusOut, "Reached the limit of %d referenced JSON documents, not following more\n", maxRefs)
+				return imageURLs
+			}
+			fetched++
+
+			fmt.Fprintf(statusOut, "Following JSON ref (depth %d): %s\n", depth, ref)
+			doc, err := fetchJSON(client, ref)
+			if err != nil {
+				fmt.Fprintf(statusOut, "  Warning: %v\n", err)
+				continue
+			}
+
+			extractImageURLs(doc, filter, &imageURLs)
+			collectJSONRefs(doc, &next)
+		}
+		refs = next
+	}
+
+	return imageURLs
+}
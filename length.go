@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Response body that counts the bytes read from it for -verify-length, and
+// whether it was read to its end
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	ended bool // Reached EOF, or a premature EOF reported by the transport
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		c.ended = true
+	}
+	return n, err
+}
+
+// Compare the bytes received with the Content-Length of the response,
+// which is that of the requested range for resumed downloads. Responses
+// without a length (chunked or transparently decompressed) always pass.
+func checkLength(expected, received int64) error {
+	if expected < 0 || received == expected {
+		return nil
+	}
+	return fmt.Errorf("%w: received %d of %d bytes", errLengthMismatch, received, expected)
+}
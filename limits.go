@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Value of the -limit flag: a default limit in MB and optional per-extension
+// limits, written as "1" or "jpg=1,png=2,gif=0.5" or "1,png=2"
+type limitFlag struct {
+	defaultMB float64
+	byExt     map[string]float64
+}
+
+// Normalize an extension for limit lookups (".JPEG" -> "jpg")
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if ext == "jpeg" {
+		return "jpg"
+	}
+	return ext
+}
+
+func (f *limitFlag) String() string {
+	if f == nil {
+		return "0"
+	}
+	parts := []string{strconv.FormatFloat(f.defaultMB, 'g', -1, 64)}
+	exts := make([]string, 0, len(f.byExt))
+	for ext := range f.byExt {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		parts = append(parts, fmt.Sprintf("%s=%g", ext, f.byExt[ext]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *limitFlag) Set(value string) error {
+	f.defaultMB = 0
+	f.byExt = make(map[string]float64)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		ext, mb, hasExt := strings.Cut(part, "=")
+		if !hasExt {
+			mb = part
+		}
+
+		limit, err := strconv.ParseFloat(strings.TrimSpace(mb), 64)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid limit %q", part)
+		}
+
+		if !hasExt {
+			f.defaultMB = limit
+			continue
+		}
+		ext = normalizeExt(strings.TrimSpace(ext))
+		if ext == "" {
+			return fmt.Errorf("invalid limit %q, missing extension", part)
+		}
+		f.byExt[ext] = limit
+	}
+	return nil
+}
+
+// Format of an image for per-format settings, like "jpg", detected from its
+// data or, failing that, its filename extension
+func imageFormat(data []byte, filename string) string {
+	ext := sniffExtension(data)
+	if ext == "" {
+		ext = filepath.Ext(filename)
+	}
+	return normalizeExt(ext)
+}
+
+// Parse a -compress-formats list like "jpg,webp" into normalized formats.
+// Returns nil for an empty list, which compresses all formats.
+func parseCompressFormats(value string) (map[string]bool, error) {
+	names := splitList(value)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	formats := make(map[string]bool)
+	for _, name := range names {
+		format := normalizeExt(name)
+		known := false
+		for _, ext := range contentTypeExtensions {
+			known = known || ext == "."+format
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown format %q (expected jpg, png, gif, bmp, webp or svg)", name)
+		}
+		formats[format] = true
+	}
+	return formats, nil
+}
+
+// Describe the limits for the console
+func (f *limitFlag) describe() string {
+	if len(f.byExt) == 0 {
+		return fmt.Sprintf("%.2fMB", f.defaultMB)
+	}
+	desc := f.String()
+	if f.defaultMB == 0 {
+		desc = strings.TrimPrefix(desc, "0,")
+	}
+	return desc + " (MB)"
+}
@@ -2,20 +2,35 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
+	// Image decoders are registered by blank imports. To support another
+	// format, import its decoder here and add its content type to
+	// contentTypeExtensions; -list-formats shows what this build supports.
 	_ "image/gif"
 	_ "image/png"
 )
@@ -53,54 +68,211 @@ func isPossibleImageURL(s string) bool {
 	return false
 }
 
-// Get file extension from Content-Type
+// Known image content types and their file extensions
+var contentTypeExtensions = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/jpg":     ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/bmp":     ".bmp",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+// Get file extension from Content-Type, trying the types added with
+// -extra-content-type first
 func getExtensionFromContentType(contentType string) string {
-	contentType = strings.ToLower(strings.Split(contentType, ";")[0])
-	contentType = strings.TrimSpace(contentType)
+	contentType = normalizeContentType(contentType)
 
-	extensions := map[string]string{
-		"image/jpeg":    ".jpg",
-		"image/jpg":     ".jpg",
-		"image/png":     ".png",
-		"image/gif":     ".gif",
-		"image/bmp":     ".bmp",
-		"image/webp":    ".webp",
-		"image/svg+xml": ".svg",
+	if ext, ok := extraContentTypes[contentType]; ok {
+		return ext
 	}
-
-	if ext, ok := extensions[contentType]; ok {
+	if ext, ok := contentTypeExtensions[contentType]; ok {
 		return ext
 	}
 	return ""
 }
 
-// Recursively traverse JSON object and extract all image links
-func extractImageURLs(data interface{}, urls *[]string) {
-	switch v := data.(type) {
-	case map[string]interface{}:
-		// Traverse JSON object
-		for _, value := range v {
-			extractImageURLs(value, urls)
-		}
-	case []interface{}:
-		// Traverse JSON array
-		for _, item := range v {
-			extractImageURLs(item, urls)
+// Detect the file extension of image data from its magic bytes. Only built-in
+// types are detected, so -extra-content-type can't relabel generic data.
+func sniffExtension(data []byte) string {
+	return contentTypeExtensions[normalizeContentType(http.DetectContentType(data))]
+}
+
+// Check whether two extensions refer to the same format
+func sameExtension(a, b string) bool {
+	a = strings.ToLower(a)
+	b = strings.ToLower(b)
+	if a == ".jpeg" {
+		a = ".jpg"
+	}
+	if b == ".jpeg" {
+		b = ".jpg"
+	}
+	return a == b
+}
+
+// Recursively traverse JSON object and extract the image links the filter
+// allows (nil = all)
+func extractImageURLs(data interface{}, filter *urlFilter, urls *[]string) {
+	filter.walk(data, "", false, func(u string) {
+		*urls = append(*urls, u)
+	})
+}
+
+// Recursively traverse JSON object and call fn for each image link as soon
+// as it is found, without collecting them. Links are not deduplicated.
+func WalkImageURLs(data interface{}, fn func(url string)) {
+	var filter *urlFilter
+	filter.walk(data, "", false, fn)
+}
+
+// Call fn for the image links in a JSON string value, at most limit of them
+// (0 = no limit). Reports whether links beyond the limit were left out.
+func matchImageURLs(s string, limit int, fn func(url string)) (capped bool) {
+	// First check if string contains explicit image URLs
+	n := -1
+	if limit > 0 {
+		n = limit + 1
+	}
+	matches := imageURLPattern.FindAllString(s, n)
+	if limit > 0 && len(matches) > limit {
+		matches, capped = matches[:limit], true
+	}
+	for _, match := range matches {
+		fn(match)
+	}
+
+	// If no explicit image URLs found, check if it's possibly an image URL
+	if len(matches) == 0 && isPossibleImageURL(s) {
+		fn(s)
+	}
+	return capped
+}
+
+// Stream the image links of a JSON document over a channel, which is closed
+// when extraction finishes. The channel must be drained, or the extracting
+// goroutine is never released.
+func ExtractImageURLsChan(data interface{}) <-chan string {
+	urls := make(chan string)
+	go func() {
+		defer close(urls)
+		WalkImageURLs(data, func(u string) {
+			urls <- u
+		})
+	}()
+	return urls
+}
+
+// Number of top-level array elements handed to a worker at a time
+const extractChunkSize = 256
+
+// Extract image links from a top-level JSON array using a pool of workers.
+// Each chunk of elements is extracted into its own slice and the slices are
+// merged in element order, so the result matches extractImageURLs.
+func extractImageURLsParallel(items []interface{}, workers int, filter *urlFilter) []string {
+	chunkCount := (len(items) + extractChunkSize - 1) / extractChunkSize
+	results := make([][]string, chunkCount)
+
+	chunks := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				start := chunk * extractChunkSize
+				end := min(start+extractChunkSize, len(items))
+				var urls []string
+				for _, item := range items[start:end] {
+					extractImageURLs(item, filter, &urls)
+				}
+				results[chunk] = urls
+			}
+		}()
+	}
+
+	for chunk := 0; chunk < chunkCount; chunk++ {
+		chunks <- chunk
+	}
+	close(chunks)
+	wg.Wait()
+
+	var urls []string
+	for _, chunkURLs := range results {
+		urls = append(urls, chunkURLs...)
+	}
+	return urls
+}
+
+// Remove duplicate URLs, keeping the first occurrence
+func dedupeURLs(urls []string) []string {
+	return dedupeURLsBy(urls, func(u string) string { return u })
+}
+
+// Remove URLs with the same key, keeping the first occurrence
+func dedupeURLsBy(urls []string, key func(string) string) []string {
+	seen := make(map[string]bool, len(urls))
+	unique := make([]string, 0, len(urls))
+	for _, u := range urls {
+		k := key(u)
+		if seen[k] {
+			continue
 		}
-	case string:
-		// First check if string contains explicit image URLs
-		matches := imageURLPattern.FindAllString(v, -1)
-		*urls = append(*urls, matches...)
+		seen[k] = true
+		unique = append(unique, u)
+	}
+	return unique
+}
+
+// URL without its query string and fragment, for deduplicating cache-busted
+// links like a.jpg?v=1 and a.jpg?v=2
+func stripQuery(u string) string {
+	if i := strings.IndexAny(u, "?#"); i >= 0 {
+		return u[:i]
+	}
+	return u
+}
+
+// Write URLs to a file, one per line
+func writeURLList(path string, urls []string) error {
+	var buf bytes.Buffer
+	for _, u := range urls {
+		buf.WriteString(u)
+		buf.WriteByte('\n')
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Quality ladder tried when compressing to a size limit
+var defaultQualities = []int{85, 75, 65, 55, 45, 35, 25}
 
-		// If no explicit image URLs found, check if it's possibly an image URL
-		if len(matches) == 0 && isPossibleImageURL(v) {
-			*urls = append(*urls, v)
+// With -post-sharpen, qualities at or below this are encoded from a copy
+// sharpened by postSharpenAmount
+const (
+	postSharpenQuality = 45
+	postSharpenAmount  = 0.5
+)
+
+// Build the quality ladder, starting at the user's quality if set
+func qualityLadder(startQuality int) []int {
+	if startQuality <= 0 {
+		return defaultQualities
+	}
+	qualities := []int{startQuality}
+	for _, q := range defaultQualities {
+		if q < startQuality {
+			qualities = append(qualities, q)
 		}
 	}
+	return qualities
 }
 
-// Compress image if it exceeds the size limit
-func compressImage(data []byte, limitMB float64) ([]byte, error) {
+// Compress image if it exceeds the size limit.
+// jpegQuality sets the first quality tried (0 = default ladder). With
+// sharpen, qualities at or below postSharpenQuality encode a sharpened copy
+// to offset the blur of heavy compression.
+func compressImage(data []byte, limitMB float64, jpegQuality int, sharpen bool) ([]byte, error) {
 	limitBytes := int64(limitMB * 1024 * 1024)
 
 	// If image is within limit, return original
@@ -108,27 +280,45 @@ func compressImage(data []byte, limitMB float64) ([]byte, error) {
 		return data, nil
 	}
 
+	// Keep animations rather than saving a single frame
+	if format := animatedFormat(data); format != "" {
+		return nil, fmt.Errorf("animated %s %w", format, errAnimatedImage)
+	}
+
 	// Decode image
 	img, format, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode image: %v", err)
 	}
 
+	// Sharpened copy for low qualities, made on first use
+	var sharpened image.Image
+	imageFor := func(quality int) (image.Image, string) {
+		if !sharpen || quality > postSharpenQuality {
+			return img, ""
+		}
+		if sharpened == nil {
+			sharpened = unsharpMask(img, postSharpenAmount)
+		}
+		return sharpened, ", sharpened"
+	}
+
 	// Try different quality levels to meet the size limit
-	qualities := []int{85, 75, 65, 55, 45, 35, 25}
+	qualities := qualityLadder(jpegQuality)
 
 	for _, quality := range qualities {
 		var buf bytes.Buffer
+		src, note := imageFor(quality)
 
 		switch format {
 		case "jpeg", "jpg":
-			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+			err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality})
 		case "png":
 			// PNG compression is lossless, so we convert to JPEG for lossy compression
-			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+			err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality})
 		case "gif":
 			// GIF compression - just return original or convert to JPEG
-			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+			err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality})
 		default:
 			return data, nil // Return original for unsupported formats
 		}
@@ -139,220 +329,2368 @@ func compressImage(data []byte, limitMB float64) ([]byte, error) {
 
 		// Check if compressed size is within limit
 		if int64(buf.Len()) <= limitBytes {
-			fmt.Printf("  Compressed from %.2fMB to %.2fMB (quality: %d)\n",
+			fmt.Printf("  Compressed from %.2fMB to %.2fMB (quality: %d%s)\n",
 				float64(len(data))/1024/1024,
 				float64(buf.Len())/1024/1024,
-				quality)
+				quality, note)
 			return buf.Bytes(), nil
 		}
 	}
 
 	// If still too large, return the most compressed version
 	var buf bytes.Buffer
-	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 20})
-	fmt.Printf("  Compressed from %.2fMB to %.2fMB (quality: 20 - minimum)\n",
+	src, note := imageFor(20)
+	jpeg.Encode(&buf, src, &jpeg.Options{Quality: 20})
+	fmt.Printf("  Compressed from %.2fMB to %.2fMB (quality: 20 - minimum%s)\n",
 		float64(len(data))/1024/1024,
-		float64(buf.Len())/1024/1024)
+		float64(buf.Len())/1024/1024, note)
 	return buf.Bytes(), nil
 }
 
-// Download image to specified directory
-func downloadImage(imageURL, outputDir string, index int, limitMB float64) error {
-	// Parse URL
-	parsedURL, err := url.Parse(imageURL)
-	if err != nil {
-		return fmt.Errorf("invalid URL: %v", err)
+// Output directory layouts
+const (
+	layoutFlat   = "flat"
+	layoutByHost = "by-host"
+	layoutByExt  = "by-ext"
+)
+
+// Settings that control how each image is downloaded and saved
+type Options struct {
+	LimitMB     float64            // Maximum image size in MB (0 = no limit)
+	ExtLimits   map[string]float64 // Per-format limits in MB, keyed like "jpg", overriding LimitMB
+	JPEGQuality int                // First JPEG quality tried when compressing (0 = default)
+	PostSharpen bool               // Sharpen images compressed at low JPEG qualities
+
+	// Formats compressed when over their limit, keyed like "jpg". Images in
+	// other formats are saved as downloaded with a warning (nil = all).
+	CompressFormats map[string]bool
+
+	// Images are only compressed when larger than LimitMB * CompressRatio,
+	// so images marginally over the limit keep their original quality
+	CompressRatio float64
+	Layout        string // Output layout: flat, by-host or by-ext
+
+	// Also save the uncompressed download of compressed images under originals/
+	PreserveOriginal bool
+
+	Bandwidth int64 // Maximum read rate of each download in bytes per second (0 = unlimited)
+
+	Since        time.Time // Skip images whose Last-Modified is not after this time (zero = off)
+	SinceMissing string    // Without Last-Modified: "download" (default) or "skip"
+
+	DirMode  os.FileMode // Permissions of created directories (0 = 0755 minus umask)
+	FileMode os.FileMode // Permissions of created files (0 = 0644 minus umask)
+
+	Retries    int                 // Extra attempts for network errors, 429 and 5xx responses
+	HostHeader string              // Host header sent instead of the URL's host
+	Mirrors    map[string][]string // Fallback hosts tried when a host fails
+
+	// Retries for timeouts and for 429 and 5xx responses, replacing Retries
+	// for them (0 = Retries, negative = none)
+	TimeoutRetries int
+	StatusRetries  int
+
+	HostFailureThreshold int     // Skip a host after this many consecutive failures (0 = never)
+	MaxRetriesPerHost    int     // Total retries allowed per host across all images (0 = unlimited)
+	RespectRobots        bool    // Skip images disallowed by their host's robots.txt
+	MinBytes             int64   // Skip responses smaller than this many bytes (0 = keep all)
+	FixExtensions        bool    // Rename files whose extension doesn't match their content
+	StrictValidate       bool    // Fully decode images and reject corrupt or non-image responses
+	VerifyLength         bool    // Fail downloads whose size differs from their Content-Length
+	SniffBytes           int     // Bytes inspected to detect the image format (0 = 1024)
+	StripMetadata        bool    // Remove EXIF, GPS and other metadata from saved images
+	ConvertTo            string  // Re-encode every image to this extension, e.g. ".png" ("" = keep format)
+	MaxMegapixels        float64 // Downscale images with more pixels than this many million (0 = keep size)
+	Resume               bool    // Keep interrupted downloads as .part files and resume them
+
+	// Download images whose filename is taken and, if the existing file
+	// differs, save them under a content-hash suffix instead of skipping
+	RenameExisting bool
+
+	// Run downloads as a pipeline of download, compress and write stages
+	// with this many workers each, connected by channels holding at most
+	// PipelineBuffer images (0 = 8). Zero DownloadWorkers downloads one
+	// image at a time.
+	DownloadWorkers int
+	CompressWorkers int
+	WriteWorkers    int
+	PipelineBuffer  int
+
+	// Write <image>.json next to each saved image with its source URL,
+	// download time, Content-Type, sizes and the changes made to it
+	Sidecar bool
+
+	// Write <image>.headers next to each saved image with the status line,
+	// headers and trailers of its response
+	SaveHeaders bool
+
+	// Extra outputs written for each downloaded image, to subdirectories
+	// named after them: original, thumbnail, jpg, png or gif
+	Variants []string
+
+	// Filenames without extension chosen from the JSON data, keyed by URL.
+	// They replace the URL path name; the extension still comes from the
+	// URL or Content-Type.
+	Names map[string]string
+
+	// Subdirectories chosen from the JSON data, keyed by URL. Images without
+	// one are saved at the top of the output directory.
+	Groups map[string]string
+
+	// Put at most this many images into each part-001, part-002 etc.
+	// subdirectory, by their position in the URL list (0 = no shards)
+	ShardSize int
+
+	// Zero-pad indices in fallback filenames to the digits of the URL
+	// count, but at most this many (0 = no padding)
+	MaxIndexWidth int
+	indexWidth    int // Padding for the current downloadAll call
+
+	// Prepended to index-based fallback filenames like image_007, so inputs
+	// sharing an output directory don't reuse each other's names
+	IndexPrefix string
+
+	retryBudget *retryBudget // Retries spent per host in the current downloadAll call
+
+	// NameFunc, if set, chooses the output filename for each image and
+	// replaces the built-in URL path and Content-Type naming. It is called
+	// after the response headers arrive, so existing files are only detected
+	// once the request has been made. Returning "" uses the built-in name.
+	NameFunc func(url string, resp *http.Response, index int) string
+
+	// OnRequest, if set, is called just before each image request is sent,
+	// including retries, so it can add fresh credentials such as signed
+	// headers or short-lived bearer tokens.
+	OnRequest func(req *http.Request) error
+
+	// Progress callbacks used by downloadAll. OnStart is called once with
+	// the number of URLs, OnImage before each download starts and
+	// OnProgress after each download finishes.
+	OnStart    func(total int)
+	OnImage    func(index, total int, url string)
+	OnProgress func(done, total int, current Result)
+}
+
+// Retries allowed per failure cause
+func (opts Options) retryPolicy() retryPolicy {
+	limit := func(n int) int {
+		if n == 0 {
+			return opts.Retries
+		}
+		return max(n, 0)
 	}
+	return retryPolicy{network: opts.Retries, timeout: limit(opts.TimeoutRetries), status: limit(opts.StatusRetries)}
+}
+
+// Permissions for created directories and files
+func (opts Options) modes() fileModes {
+	return fileModes{dir: opts.DirMode, file: opts.FileMode}
+}
 
-	// Get filename
-	filename := filepath.Base(parsedURL.Path)
-	if filename == "" || filename == "." || filename == "/" {
-		filename = fmt.Sprintf("image_%d", index)
+// Pick the size limit for an image, based on its detected format or,
+// failing that, its filename extension
+func (opts Options) limitFor(data []byte, filename string) float64 {
+	if len(opts.ExtLimits) > 0 {
+		if limit, ok := opts.ExtLimits[imageFormat(data, filename)]; ok {
+			return limit
+		}
 	}
+	return opts.LimitMB
+}
 
-	// Clean special characters in filename
-	filename = strings.ReplaceAll(filename, "?", "_")
-	filename = strings.ReplaceAll(filename, "&", "_")
+// Check whether an image over its limit may be compressed, given its format
+func (opts Options) compressesFormat(data []byte, filename string) bool {
+	return opts.CompressFormats == nil || opts.CompressFormats[imageFormat(data, filename)]
+}
 
-	// If filename has no extension, try to get it from Content-Type
-	if !strings.Contains(filename, ".") {
-		filename = fmt.Sprintf("%s_%d", filename, index)
+// Shard subdirectory of the image at a 1-based index under ShardSize, like
+// "part-001", or "" without sharding
+func (opts Options) shardFor(index int) string {
+	if opts.ShardSize <= 0 || index <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("part-%03d", (index-1)/opts.ShardSize+1)
+}
+
+// Subdirectory of an image within the output, from its shard and group
+func (opts Options) imageDir(imageURL string, index int) string {
+	return path.Join(opts.shardFor(index), opts.Groups[imageURL])
+}
+
+// Build the output path for a file, relative to the sink, according to the
+// output layout, inside dir (see imageDir) if it isn't empty
+func outputPathFor(layout, dir string, parsedURL *url.URL, filename string) string {
+	if dir != "" {
+		return path.Join(dir, outputPathFor(layout, "", parsedURL, filename))
 	}
+	switch layout {
+	case layoutByHost:
+		host := sanitizeFilename(parsedURL.Hostname()) // IPv6 addresses contain colons
+		if host == "" {
+			host = "unknown-host"
+		}
+		return path.Join(host, filename)
+	case layoutByExt:
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+		if ext == "" {
+			ext = "unknown"
+		}
+		return path.Join(ext, filename)
+	default:
+		return filename
+	}
+}
 
-	// Build full output path
-	outputPath := filepath.Join(outputDir, filename)
+// Most filesystems limit a single path component to 255 bytes. Image names
+// are kept shorter, so the hash suffix of -rename-existing, like
+// "-1a2b3c4d-2", and sidecar extensions like ".headers" still fit.
+const maxFilenameBytes = 255 - 24
 
-	// Check if file already exists
-	if _, err := os.Stat(outputPath); err == nil {
-		fmt.Printf("File already exists, skipping: %s\n", filename)
-		return nil
+// Shorten overly long filenames while keeping the extension.
+// The stem is truncated and a short hash of the full name is appended so
+// that different long names stay unique after truncation.
+func fitFilename(filename string) string {
+	if len(filename) <= maxFilenameBytes {
+		return filename
 	}
 
-	// Send HTTP request
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	ext := filepath.Ext(filename)
+	if len(ext) > 16 {
+		// Not a real extension, treat the whole name as the stem
+		ext = ""
 	}
-	resp, err := client.Get(imageURL)
-	if err != nil {
-		return fmt.Errorf("download failed: %v", err)
+	stem := strings.TrimSuffix(filename, ext)
+
+	sum := sha1.Sum([]byte(filename))
+	suffix := "_" + hex.EncodeToString(sum[:4])
+
+	keep := maxFilenameBytes - len(ext) - len(suffix)
+	// Don't cut a multi-byte UTF-8 character in half
+	for keep > 0 && !utf8.RuneStart(stem[keep]) {
+		keep--
 	}
-	defer resp.Body.Close()
 
-	// Check HTTP status code
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP error: %s", resp.Status)
+	return stem[:keep] + suffix + ext
+}
+
+// Build the default filename from the URL path. Index suffixes are
+// zero-padded to width digits so they sort naturally, and names with an
+// index start with prefix, if any.
+func defaultFilename(parsedURL *url.URL, prefix string, index, width int) string {
+	// Decoded paths can hold characters that aren't valid in filenames.
+	// & is replaced too, as shells treat it specially.
+	filename := path.Base(parsedURL.Path)
+	if filename == "/" {
+		filename = ""
+	}
+	filename = strings.ReplaceAll(sanitizeFilename(filename), "&", "_")
+	if filename == "" {
+		filename = fmt.Sprintf("image_%0*d", width, index)
 	}
 
-	// If filename has no extension, try to infer from Content-Type
+	// If filename has no extension, it is inferred from Content-Type later
 	if !strings.Contains(filename, ".") {
-		contentType := resp.Header.Get("Content-Type")
-		ext := getExtensionFromContentType(contentType)
-		if ext != "" {
-			filename = filename + ext
-			outputPath = filepath.Join(outputDir, filename)
+		filename = fmt.Sprintf("%s_%0*d", filename, width, index)
+		if prefix != "" {
+			filename = prefix + "_" + filename
 		}
 	}
+	return fitFilename(filename)
+}
 
-	// Read image data into memory
-	imageData, err := io.ReadAll(resp.Body)
+// Digits needed for the largest of total indices, capped at maxWidth
+func indexWidth(total, maxWidth int) int {
+	return min(len(strconv.Itoa(total)), maxWidth)
+}
+
+// Doer sends HTTP requests. *http.Client satisfies it; tests and embedders
+// can supply a client backed by httptest.Server or a canned RoundTripper.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Returned by downloadImage for images that are skipped
+var (
+	errAlreadyExists = errors.New("file already exists")
+	errTooSmall      = errors.New("response smaller than minimum size")
+	errCircuitOpen   = errors.New("host circuit open")
+	errNotModified   = errors.New("not modified since the -since date")
+	errRobots        = errors.New("disallowed by robots.txt")
+)
+
+// Returned by downloadImage for responses rejected by StrictValidate
+var (
+	errCorruptImage = errors.New("corrupt image")
+	errNotImage     = errors.New("response is not an image")
+)
+
+// Returned by downloadImage under VerifyLength for bodies whose size differs
+// from their Content-Length
+var errLengthMismatch = errors.New("length mismatch")
+
+// Set by -perceptual-dedup on the results of the images it deleted
+var errNearDuplicate = errors.New("near duplicate")
+
+// Returned by runDownload after reporting an error
+var errRunFailed = errors.New("run failed")
+
+// Why an image wasn't downloaded, for accounting of skipped URLs in the
+// manifest and summary
+type SkipReason string
+
+const (
+	SkipNone             SkipReason = ""                  // Downloaded, or failed with an error
+	SkipAlreadyExists    SkipReason = "already_exists"    // A file with its name was already saved
+	SkipTooSmall         SkipReason = "too_small"         // Smaller than MinBytes
+	SkipFiltered         SkipReason = "filtered"          // Not modified after Since
+	SkipBlocked          SkipReason = "blocked"           // Its host's circuit breaker is open
+	SkipNotImage         SkipReason = "not_image"         // Rejected by StrictValidate as a non-image
+	SkipRobotsDisallowed SkipReason = "robots_disallowed" // Disallowed by the host's robots.txt
+	SkipNearDuplicate    SkipReason = "near_duplicate"    // Deleted by -perceptual-dedup after downloading
+)
+
+// Reason the image of a result was skipped, SkipNone if it was downloaded or
+// failed
+func (r Result) SkipReason() SkipReason {
+	switch {
+	case errors.Is(r.Err, errAlreadyExists):
+		return SkipAlreadyExists
+	case errors.Is(r.Err, errTooSmall):
+		return SkipTooSmall
+	case errors.Is(r.Err, errNotModified):
+		return SkipFiltered
+	case errors.Is(r.Err, errCircuitOpen):
+		return SkipBlocked
+	case errors.Is(r.Err, errNotImage):
+		return SkipNotImage
+	case errors.Is(r.Err, errRobots):
+		return SkipRobotsDisallowed
+	case errors.Is(r.Err, errNearDuplicate):
+		return SkipNearDuplicate
+	default:
+		return SkipNone
+	}
+}
+
+// Subdirectory of the output where -preserve-original keeps uncompressed files
+const originalsDir = "originals"
+
+// A file written by downloadImage
+type savedImage struct {
+	Path         string // Location of the saved file
+	Size         int64  // Size of the saved file in bytes
+	OriginalPath string // Location of the preserved uncompressed file, if any
+
+	Variants map[string]string // Locations of the Variants written, by name
+}
+
+// An image read from the network, carried through processing to the sink
+type pendingImage struct {
+	url        string
+	parsedURL  *url.URL
+	dir        string // Shard and group subdirectory, see imageDir
+	filename   string
+	outputPath string
+	partPath   string // .part file of a resumable download, removed once written
+	data       []byte
+
+	downloaded     []byte // Data as downloaded, which Variants are made from
+	downloadedPath string
+	originalData   []byte // Uncompressed data kept by PreserveOriginal
+	originalPath   string
+
+	// Provenance recorded by Sidecar
+	fetched     time.Time
+	contentType string
+	transforms  []string // Changes made to the download, e.g. "compressed"
+
+	headers []byte // Response headers saved by SaveHeaders
+}
+
+// Download image and save it to the sink.
+// Returns the saved file, or the existing file together with errAlreadyExists.
+func downloadImage(client Doer, imageURL string, sink Sink, index int, opts Options) (savedImage, error) {
+	img, existing, err := fetchImage(client, imageURL, sink, index, opts)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %v", err)
+		return existing, err
 	}
+	processImage(img, opts)
+	return writeImage(img, sink, opts)
+}
 
-	// Apply compression if limit is set
-	if limitMB > 0 {
-		originalSize := float64(len(imageData)) / 1024 / 1024
-		if originalSize > limitMB {
-			fmt.Printf("  Image size %.2fMB exceeds limit %.2fMB, compressing...\n", originalSize, limitMB)
-			ext := filepath.Ext(filename)
-			imageData, err = compressImage(imageData, limitMB)
-			if err != nil {
-				fmt.Printf("  Warning: compression failed, saving original: %v\n", err)
-			} else {
-				// Update filename extension if changed during compression
-				if ext == ".png" || ext == ".gif" {
-					filename = strings.TrimSuffix(filename, ext) + ".jpg"
-					outputPath = filepath.Join(outputDir, filename)
-				}
-			}
+// Download and check an image. Returns the existing file together with
+// errAlreadyExists if its name is taken.
+func fetchImage(client Doer, imageURL string, sink Sink, index int, opts Options) (*pendingImage, savedImage, error) {
+	// Parse URL
+	parsedURL, err := url.Parse(imageURL)
+	if err != nil {
+		return nil, savedImage{}, fmt.Errorf("invalid URL: %v", err)
+	}
+
+	// Without a naming callback the filename is known before the request,
+	// so existing files can be skipped without downloading them
+	dir := opts.imageDir(imageURL, index)
+	var filename, outputPath string
+	var inferExt bool // Take the extension from the Content-Type
+	if opts.NameFunc == nil {
+		filename = defaultFilename(parsedURL, opts.IndexPrefix, index, opts.indexWidth)
+		inferExt = !strings.Contains(filename, ".")
+		if name := opts.Names[imageURL]; name != "" {
+			filename, inferExt = dataFilename(name, parsedURL)
+		}
+		outputPath = outputPathFor(opts.Layout, dir, parsedURL, filename)
+
+		// Check if file already exists
+		if size, exists, err := sink.Stat(outputPath); err != nil {
+			return nil, savedImage{}, fmt.Errorf("failed to check existing file: %v", err)
+		} else if exists && !opts.RenameExisting {
+			return nil, savedImage{Path: sink.Location(outputPath), Size: size}, errAlreadyExists
 		}
 	}
 
-	// Create output file
-	outFile, err := os.Create(outputPath)
+	// Build HTTP request
+	req, err := http.NewRequest(http.MethodGet, imageURL, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+		return nil, savedImage{}, fmt.Errorf("invalid request: %v", err)
+	}
+	if opts.HostHeader != "" {
+		req.Host = opts.HostHeader
 	}
-	defer outFile.Close()
 
-	// Write to file
-	_, err = outFile.Write(imageData)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
+	// Continue an interrupted download from its .part file
+	var partPath string
+	var resumeOffset int64
+	if dir, ok := sink.(*dirSink); ok && opts.Resume && outputPath != "" {
+		partPath = partPathFor(dir.Location(outputPath), imageURL)
+		resumeOffset = prepareResume(req, partPath, imageURL)
 	}
 
-	finalSize := float64(len(imageData)) / 1024 / 1024
-	fmt.Printf("✓ Downloaded: %s (%.2fMB)\n", filename, finalSize)
-	return nil
-}
+	// Let the server skip unchanged images
+	if !opts.Since.IsZero() {
+		req.Header.Set("If-Modified-Since", opts.Since.UTC().Format(http.TimeFormat))
+	}
 
-// Get user's Download directory
-func getDownloadDir() (string, error) {
-	homeDir, err := os.UserHomeDir()
+	// Send HTTP request
+	resp, err := sendWithRetry(client, req, opts.retryPolicy(), opts.Mirrors[parsedURL.Host], opts.retryBudget)
 	if err != nil {
-		return "", err
+		return nil, savedImage{}, fmt.Errorf("download failed: %v", err)
 	}
+	defer resp.Body.Close()
 
-	// Cross-platform Download directory
-	downloadDir := filepath.Join(homeDir, "Downloads")
-	return downloadDir, nil
-}
-
-func main() {
-	// Define command line flags
-	var limitMB float64
-	flag.Float64Var(&limitMB, "limit", 0, "Maximum image size in MB (0 = no limit, download original)")
-	flag.Parse()
+	// Check HTTP status code
+	if resp.StatusCode == http.StatusNotModified && !opts.Since.IsZero() {
+		return nil, savedImage{}, errNotModified
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && partPath != "" {
+		removePartFiles(partPath)
+		return nil, savedImage{}, fmt.Errorf("HTTP error: %s (partial download discarded)", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK && !(resumeOffset > 0 && resp.StatusCode == http.StatusPartialContent) {
+		return nil, savedImage{}, fmt.Errorf("HTTP error: %s", resp.Status)
+	}
 
-	// Check command line arguments
-	if flag.NArg() < 1 {
-		fmt.Println("Usage: json-shake [options] <json-file-path>")
-		fmt.Println("Options:")
-		fmt.Println("  -limit <MB>  Maximum image size in MB (default: 0, no compression)")
-		fmt.Println("Example: json-shake data.json")
-		fmt.Println("Example: json-shake -limit 1 data.json")
-		os.Exit(1)
+	// Skip images not modified after -since, before reading the body
+	if !opts.Since.IsZero() {
+		if err := checkModifiedSince(resp, opts.Since, opts.SinceMissing); err != nil {
+			return nil, savedImage{}, err
+		}
 	}
 
-	jsonFilePath := flag.Arg(0)
+	// Let the naming callback choose the filename from the response
+	if opts.NameFunc != nil {
+		filename = opts.NameFunc(imageURL, resp, index)
+		if filename == "" {
+			filename = defaultFilename(parsedURL, opts.IndexPrefix, index, opts.indexWidth)
+		} else if !filepath.IsLocal(filename) {
+			return nil, savedImage{}, fmt.Errorf("invalid filename from NameFunc: %q", filename)
+		}
+		filename = fitFilename(filename)
+		outputPath = outputPathFor(opts.Layout, dir, parsedURL, filename)
 
-	// Read JSON file
-	jsonData, err := os.ReadFile(jsonFilePath)
-	if err != nil {
-		fmt.Printf("Failed to read file: %v\n", err)
-		os.Exit(1)
+		// Check if file already exists
+		if size, exists, err := sink.Stat(outputPath); err != nil {
+			return nil, savedImage{}, fmt.Errorf("failed to check existing file: %v", err)
+		} else if exists && !opts.RenameExisting {
+			return nil, savedImage{Path: sink.Location(outputPath), Size: size}, errAlreadyExists
+		}
 	}
 
-	// Parse JSON
-	var data interface{}
-	err = json.Unmarshal(jsonData, &data)
-	if err != nil {
-		fmt.Printf("Failed to parse JSON: %v\n", err)
-		os.Exit(1)
+	// Limit the read rate for shared connections
+	if opts.Bandwidth > 0 {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{newThrottledReader(resp.Body, opts.Bandwidth), resp.Body}
 	}
 
-	// Extract all image URLs
-	var imageURLs []string
-	extractImageURLs(data, &imageURLs)
+	// Look at the first bytes without consuming them. Resumed responses
+	// start mid-file and have nothing to sniff.
+	var head []byte
+	sniffed := resp.StatusCode != http.StatusPartialContent
+	if sniffed {
+		peeked, peekedHead, err := peekBody(resp.Body, opts.sniffBytes())
+		if err != nil {
+			return nil, savedImage{}, fmt.Errorf("failed to read response: %v", err)
+		}
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{peeked, resp.Body}
+		head = peekedHead
+	}
 
-	if len(imageURLs) == 0 {
-		fmt.Println("No image links found")
-		os.Exit(0)
+	// Reject error pages and other non-images before reading them
+	// Types added with -extra-content-type can't be checked and are trusted.
+	extraType := isExtraContentType(resp.Header.Get("Content-Type"))
+	if opts.StrictValidate && sniffed && !extraType && !looksLikeImage(head) {
+		if partPath != "" {
+			removePartFiles(partPath)
+		}
+		return nil, savedImage{}, errNotImage
 	}
 
-	fmt.Printf("Found %d image links\n", len(imageURLs))
+	// If filename has no extension, try to infer from Content-Type, or
+	// from the data if the type is missing or generic
+	if opts.NameFunc == nil && inferExt {
+		contentType := resp.Header.Get("Content-Type")
+		ext := getExtensionFromContentType(contentType)
+		if ext == "" && sniffed {
+			ext = sniffExtension(head)
+		}
+		if ext != "" {
+			filename = fitFilename(filename + ext)
+			outputPath = outputPathFor(opts.Layout, dir, parsedURL, filename)
+		}
+	}
 
-	// Get JSON filename (without extension)
-	jsonFileName := strings.TrimSuffix(filepath.Base(jsonFilePath), filepath.Ext(jsonFilePath))
+	// Count the bytes actually received
+	var received *countingReader
+	if opts.VerifyLength {
+		received = &countingReader{r: resp.Body}
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{received, resp.Body}
+	}
 
-	// Get Download directory
-	downloadDir, err := getDownloadDir()
-	if err != nil {
-		fmt.Printf("Failed to get Download directory: %v\n", err)
-		os.Exit(1)
+	// Read image data into memory
+	var imageData []byte
+	if partPath != "" {
+		imageData, err = readResumable(resp, partPath, imageURL, resumeOffset, opts.modes())
+	} else if imageData, err = io.ReadAll(resp.Body); err != nil {
+		err = fmt.Errorf("failed to read response: %v", err)
 	}
 
-	// Create output directory
-	outputDir := filepath.Join(downloadDir, jsonFileName)
-	err = os.MkdirAll(outputDir, 0755)
+	// Report bodies that ended early or ran long as length mismatches rather
+	// than read errors, before anything is written
+	if received != nil && received.ended {
+		if lengthErr := checkLength(resp.ContentLength, received.n); lengthErr != nil {
+			// A short .part file can still be resumed, a long one can't
+			if partPath != "" && received.n > resp.ContentLength {
+				removePartFiles(partPath)
+			}
+			err = lengthErr
+		}
+	}
 	if err != nil {
-		fmt.Printf("Failed to create directory: %v\n", err)
-		os.Exit(1)
+		return nil, savedImage{}, err
 	}
 
-	fmt.Printf("Output directory: %s\n", outputDir)
-	if limitMB > 0 {
-		fmt.Printf("Image size limit: %.2fMB\n", limitMB)
-	} else {
-		fmt.Println("No size limit, downloading original images")
+	// Discard tracking pixels and other trivially small responses
+	if opts.MinBytes > 0 && int64(len(imageData)) < opts.MinBytes {
+		if partPath != "" {
+			removePartFiles(partPath)
+		}
+		return nil, savedImage{}, errTooSmall
 	}
-	fmt.Println("Downloading images...")
 
-	// Download all images
-	successCount := 0
-	failCount := 0
-	for i, imageURL := range imageURLs {
-		fmt.Printf("[%d/%d] Downloading: %s\n", i+1, len(imageURLs), imageURL)
-		err := downloadImage(imageURL, outputDir, i+1, limitMB)
+	// Reject truncated images that would still pass a header check
+	if opts.StrictValidate && !extraType {
+		if err := validateImage(imageData, opts.sniffBytes()); err != nil {
+			if partPath != "" {
+				removePartFiles(partPath)
+			}
+			return nil, savedImage{}, err
+		}
+	}
+
+	img := &pendingImage{
+		url:            imageURL,
+		parsedURL:      parsedURL,
+		dir:            dir,
+		filename:       filename,
+		outputPath:     outputPath,
+		partPath:       partPath,
+		data:           imageData,
+		downloaded:     imageData,
+		downloadedPath: outputPath,
+		fetched:        time.Now().UTC(),
+		contentType:    resp.Header.Get("Content-Type"),
+	}
+	if opts.SaveHeaders {
+		img.headers = formatResponseHeaders(resp)
+	}
+	return img, savedImage{}, nil
+}
+
+// Convert, compress and otherwise rewrite a downloaded image as the options
+// ask. Failed steps are reported and leave the image as it was.
+func processImage(img *pendingImage, opts Options) {
+	// Convert to the requested format regardless of size
+	if opts.ConvertTo != "" {
+		converted, err := convertImage(img.data, opts.ConvertTo, opts.JPEGQuality)
 		if err != nil {
-			fmt.Printf("✗ Error: %v\n", err)
-			failCount++
+			warnf("  Warning: conversion failed, saving as downloaded: %v", err)
 		} else {
-			successCount++
+			img.data = converted
+			img.transforms = append(img.transforms, "converted to "+normalizeExt(opts.ConvertTo))
+			ext := filepath.Ext(img.filename)
+			if !sameExtension(ext, opts.ConvertTo) {
+				img.filename = fitFilename(strings.TrimSuffix(img.filename, ext) + opts.ConvertTo)
+				img.outputPath = outputPathFor(opts.Layout, img.dir, img.parsedURL, img.filename)
+			}
+		}
+	}
+
+	// Cap the resolution before the byte size
+	if opts.MaxMegapixels > 0 {
+		resized, err := limitMegapixels(img.data, opts.MaxMegapixels, opts.JPEGQuality)
+		if err != nil {
+			warnf("  Warning: downscaling failed, keeping full resolution: %v", err)
+		} else if len(resized) != len(img.data) || !bytes.Equal(resized, img.data) {
+			fmt.Printf("  Downscaled to %.1f megapixels or less (%.2fMB -> %.2fMB)\n", opts.MaxMegapixels, float64(len(img.data))/1024/1024, float64(len(resized))/1024/1024)
+			img.data = resized
+			img.transforms = append(img.transforms, fmt.Sprintf("downscaled to %g megapixels", opts.MaxMegapixels))
+		}
+	}
+
+	// Apply compression if limit is set
+	if limitMB := opts.limitFor(img.data, img.filename); limitMB > 0 {
+		originalSize := float64(len(img.data)) / 1024 / 1024
+		if originalSize > limitMB && opts.CompressRatio > 1 && originalSize <= limitMB*opts.CompressRatio {
+			// Re-encoding would cost quality for very little size gain
+			fmt.Printf("  Image size %.2fMB is only slightly over limit %.2fMB, keeping original\n", originalSize, limitMB)
+		} else if originalSize > limitMB && !opts.compressesFormat(img.data, img.filename) {
+			warnf("  Warning: image size %.2fMB exceeds limit %.2fMB, keeping original (%s isn't in -compress-formats)", originalSize, limitMB, imageFormat(img.data, img.filename))
+		} else if originalSize > limitMB {
+			fmt.Printf("  Image size %.2fMB exceeds limit %.2fMB, compressing...\n", originalSize, limitMB)
+			ext := filepath.Ext(img.filename)
+			compressed, err := compressImage(img.data, limitMB, opts.JPEGQuality, opts.PostSharpen)
+			if err != nil {
+				warnf("  Warning: compression failed, saving original: %v", err)
+			} else {
+				if opts.PreserveOriginal && !bytes.Equal(compressed, img.data) {
+					img.originalData, img.originalPath = img.data, img.outputPath
+				}
+				img.data = compressed
+				img.transforms = append(img.transforms, fmt.Sprintf("compressed to the %gMB limit", limitMB))
+
+				// Update filename extension if changed during compression
+				if ext == ".png" || ext == ".gif" {
+					img.filename = strings.TrimSuffix(img.filename, ext) + ".jpg"
+					img.outputPath = outputPathFor(opts.Layout, img.dir, img.parsedURL, img.filename)
+				}
+			}
 		}
 	}
 
-	// Output statistics
-	fmt.Println("\nDownload complete!")
-	fmt.Printf("Success: %d, Failed: %d, Total: %d\n", successCount, failCount, len(imageURLs))
+	// Remove embedded metadata such as GPS coordinates
+	if opts.StripMetadata {
+		stripped, err := stripMetadata(img.data)
+		if err != nil {
+			warnf("  Warning: failed to strip metadata, saving as downloaded: %v", err)
+		} else if len(stripped) < len(img.data) {
+			fmt.Printf("  Stripped %.1fKB of metadata\n", float64(len(img.data)-len(stripped))/1024)
+			img.data = stripped
+			img.transforms = append(img.transforms, "stripped metadata")
+		}
+	}
+
+	// Correct extensions that don't match the actual image format
+	if opts.FixExtensions {
+		if realExt := sniffExtension(img.data); realExt != "" {
+			ext := filepath.Ext(img.filename)
+			if !sameExtension(ext, realExt) {
+				fixed := strings.TrimSuffix(img.filename, ext) + realExt
+				fmt.Printf("  Fixed extension: %s -> %s\n", img.filename, fixed)
+				img.transforms = append(img.transforms, "fixed extension "+ext+" to "+realExt)
+				img.filename = fitFilename(fixed)
+				img.outputPath = outputPathFor(opts.Layout, img.dir, img.parsedURL, img.filename)
+			}
+		}
+	}
+}
+
+// Save a processed image to the sink, with its original and variants
+func writeImage(img *pendingImage, sink Sink, opts Options) (savedImage, error) {
+	// Keep both images when a different file already has this name
+	if opts.RenameExisting {
+		resolved, exists, err := resolveCollision(sink, img.outputPath, img.data)
+		if err != nil {
+			return savedImage{}, err
+		}
+		if exists {
+			if img.partPath != "" {
+				removePartFiles(img.partPath)
+			}
+			return savedImage{Path: sink.Location(resolved), Size: int64(len(img.data))}, errAlreadyExists
+		}
+		if resolved != img.outputPath {
+			fmt.Printf("  %s exists with different content, saving as %s\n", path.Base(img.outputPath), path.Base(resolved))
+			if img.originalData != nil {
+				img.originalPath = resolved
+			}
+			img.downloadedPath = strings.TrimSuffix(resolved, path.Ext(resolved)) + path.Ext(img.downloadedPath)
+			img.transforms = append(img.transforms, "renamed from "+path.Base(img.outputPath))
+			img.outputPath = resolved
+		}
+	}
+
+	// Write to file
+	if err := sink.Write(img.outputPath, img.data); err != nil {
+		return savedImage{}, fmt.Errorf("failed to write file: %v", err)
+	}
+
+	if img.partPath != "" {
+		removePartFiles(img.partPath)
+	}
+
+	saved := savedImage{Path: sink.Location(img.outputPath), Size: int64(len(img.data))}
+
+	// Keep the uncompressed download next to the compressed one
+	if img.originalData != nil {
+		img.originalPath = path.Join(originalsDir, img.originalPath)
+		if err := sink.Write(img.originalPath, img.originalData); err != nil {
+			return savedImage{}, fmt.Errorf("failed to write original: %v", err)
+		}
+		saved.OriginalPath = sink.Location(img.originalPath)
+	}
+
+	if len(opts.Variants) > 0 {
+		saved.Variants = writeVariants(sink, img.downloadedPath, img.downloaded, opts)
+	}
+
+	// Record where the image came from next to it
+	if opts.Sidecar {
+		if err := writeSidecar(sink, img); err != nil {
+			warnf("  Warning: failed to write sidecar: %v", err)
+		}
+	}
+
+	// Keep the response headers for debugging caches and CDNs
+	if opts.SaveHeaders {
+		if err := sink.Write(img.outputPath+headersExt, img.headers); err != nil {
+			warnf("  Warning: failed to write headers: %v", err)
+		}
+	}
+
+	return saved, nil
+}
+
+// Outcome of downloading a single image
+type Result struct {
+	URL   string
+	Index int    // 1-based position in the URL list
+	Path  string // Location of the saved or already existing file, empty on failure
+	Size  int64  // Size of the saved file in bytes
+	Err   error  // errAlreadyExists, errTooSmall, errCircuitOpen, errNotModified, errRobots, errCorruptImage, errNotImage, errLengthMismatch or a download error
+	Shard string // Shard subdirectory under ShardSize, like "part-001"
+
+	OriginalPath string            // Uncompressed file kept by PreserveOriginal, if any
+	Variants     map[string]string // Files written for Options.Variants, by variant
+}
+
+// Download all images into the sink, reporting progress through the Options
+// callbacks
+func downloadAll(client Doer, imageURLs []string, sink Sink, opts Options) []Result {
+	client = withRequestHook(client, opts.OnRequest)
+
+	total := len(imageURLs)
+	opts.indexWidth = indexWidth(total, opts.MaxIndexWidth)
+	opts.retryBudget = newRetryBudget(opts.MaxRetriesPerHost)
+	var robots *robotsCache
+	if opts.RespectRobots {
+		robots = newRobotsCache(client)
+	}
+	if opts.OnStart != nil {
+		opts.OnStart(total)
+	}
+
+	breaker := newHostBreaker(opts.HostFailureThreshold)
+	if opts.DownloadWorkers > 0 {
+		results := downloadPipeline(client, imageURLs, sink, opts, breaker, robots)
+		if opts.retryPolicy().enabled() {
+			opts.retryBudget.print()
+		}
+		return results
+	}
+
+	results := make([]Result, 0, total)
+	for i, imageURL := range imageURLs {
+		if opts.OnImage != nil {
+			opts.OnImage(i+1, total, imageURL)
+		}
+
+		result := Result{URL: imageURL, Index: i + 1, Shard: opts.shardFor(i + 1)}
+		host := ""
+		if parsedURL, err := url.Parse(imageURL); err == nil {
+			host = parsedURL.Host
+		}
+
+		if result.Err = precheckImage(imageURL, host, breaker, robots); result.Err == nil {
+			saved, err := downloadImage(client, imageURL, sink, i+1, opts)
+			result.Path, result.Size, result.OriginalPath, result.Err = saved.Path, saved.Size, saved.OriginalPath, err
+			result.Variants = saved.Variants
+			breaker.record(host, isHostFailure(result.Err))
+		}
+		results = append(results, result)
+
+		if opts.OnProgress != nil {
+			opts.OnProgress(i+1, total, result)
+		}
+	}
+
+	if opts.retryPolicy().enabled() {
+		opts.retryBudget.print()
+	}
+	return results
+}
+
+// Check whether an image is skipped without a request, because its host's
+// circuit is open or robots.txt disallows it
+func precheckImage(imageURL, host string, breaker *hostBreaker, robots *robotsCache) error {
+	if breaker.open(host) {
+		return fmt.Errorf("%w: %s", errCircuitOpen, host)
+	}
+	if robots != nil && !robots.allowed(imageURL) {
+		return errRobots
+	}
+	return nil
+}
+
+// Create the HTTP client shared by all downloads. A nil resolver uses the
+// system resolver.
+func newHTTPClient(jar http.CookieJar, resolver *net.Resolver) *http.Client {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Jar:     jar,
+	}
+	if resolver != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: resolver}
+		transport.DialContext = dialer.DialContext
+		client.Transport = transport
+	}
+	return client
+}
+
+// Get user's Download directory
+func getDownloadDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fallbackDownloadDir(err), nil
+	}
+
+	// Respect relocated or localized folders on Linux desktops
+	if runtime.GOOS == "linux" {
+		if dir := xdgDownloadDir(homeDir); dir != "" {
+			return dir, nil
+		}
+	}
+
+	// Cross-platform Download directory
+	downloadDir := filepath.Join(homeDir, "Downloads")
+	return downloadDir, nil
+}
+
+// Read the JSON input from a file, a -json literal or a -json-env variable.
+// Returns the raw JSON and the name used for the output directory.
+func readJSONInput(path, inlineJSON, envVar string) ([]byte, string, error) {
+	if inlineJSON != "" {
+		return []byte(inlineJSON), "inline", nil
+	}
+
+	if envVar != "" {
+		value, ok := os.LookupEnv(envVar)
+		if !ok {
+			return nil, "", fmt.Errorf("environment variable %s is not set", envVar)
+		}
+		return []byte(value), envVar, nil
+	}
+
+	jsonData, err := readInputFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Get JSON filename (without extension)
+	return jsonData, inputName(path), nil
+}
+
+// Expand input arguments containing wildcards, for shells that don't glob
+func expandInputArgs(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		if !strings.ContainsAny(arg, "*?[") || isURLInput(arg) {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", arg, err)
+		}
+		matched := 0
+		for _, match := range matches {
+			// Skip the -sidecar files of earlier downloads
+			if !isSidecarPath(match) {
+				paths = append(paths, match)
+				matched++
+			}
+		}
+		if matched == 0 {
+			return nil, fmt.Errorf("no files match %s", arg)
+		}
+	}
+	return paths, nil
+}
+
+// Settings that control how image links are extracted from JSON
+type ExtractOptions struct {
+	Workers int    // Workers used to extract from a top-level array
+	Pointer string // RFC 6901 JSON Pointer selecting the subtree to scan
+	GraphQL bool   // Scan only the data of a GraphQL response and report its errors
+	Lenient bool   // Accept comments and trailing commas in the input
+
+	FollowRefs  bool // Fetch and scan JSON documents referenced by URL
+	FollowDepth int  // Maximum depth of followed references
+	MaxRefs     int  // Maximum number of referenced documents fetched
+
+	Transforms []urlTransform // Substitutions applied to the deduplicated URLs
+
+	HTTPSOnly       bool // Skip plain http:// links
+	UpgradeInsecure bool // Rewrite plain http:// links to https://
+
+	IgnoreQueryInDedup bool // Treat URLs differing only in the query string as duplicates
+
+	Where  []whereCond // Only scan objects whose fields satisfy these conditions
+	Fields []string    // Only scan values stored under these keys
+
+	SrcsetPrefer    string // Candidates kept from srcset values: all (default) or largest
+	MaxURLLength    int    // Skip strings longer than this instead of scanning them (0 = no limit)
+	MaxURLsPerValue int    // Take at most this many links from one string (0 = no limit)
+	ParseHTML       bool   // Also take links from HTML image tags and Markdown images in strings
+	BaseURL         string // Resolves relative links found by ParseHTML (default: the input URL)
+	CSVColumn       string // Only scan this column of CSV inputs, by header name or 1-based number
+	NameField       string // Name links after this field of their enclosing object
+	GroupBy         string // Group links into subdirectories by this field of their enclosing object
+
+	// Cursor pagination of API URL inputs: the cursor is read from CursorField
+	// of each page (a key or JSON Pointer) and sent as CursorParam
+	CursorField string
+	CursorParam string
+	MaxPages    int // Maximum pages fetched per API URL
+
+	// Fetch the first bytes of extensionless links that don't look like
+	// images by name, and keep those that are
+	Sniff   bool
+	sniffed *sniffCache // Decisions shared by all inputs of a run
+
+	// Require inputs to be a top-level array of URL strings. Such arrays
+	// take the fast path without it too; other shapes then fail.
+	URLArray bool
+}
+
+// Filter applied while traversing the JSON, nil when scanning everything
+func (eopts ExtractOptions) filter() *urlFilter {
+	if len(eopts.Where) == 0 && len(eopts.Fields) == 0 && eopts.SrcsetPrefer != srcsetLargest && eopts.NameField == "" && eopts.GroupBy == "" && !eopts.Sniff && eopts.MaxURLLength <= 0 && eopts.MaxURLsPerValue <= 0 && !eopts.ParseHTML {
+		return nil
+	}
+	filter := &urlFilter{where: eopts.Where, srcsetLargest: eopts.SrcsetPrefer == srcsetLargest, maxURLLength: eopts.MaxURLLength, maxURLsPerValue: eopts.MaxURLsPerValue}
+	if eopts.ParseHTML {
+		filter.parseHTML = true
+		if eopts.BaseURL != "" {
+			filter.baseURL, _ = url.Parse(eopts.BaseURL) // Checked when parsing flags
+		}
+	}
+	if eopts.NameField != "" {
+		filter.nameField = eopts.NameField
+		filter.names = make(map[string]string)
+	}
+	if eopts.GroupBy != "" {
+		filter.groupField = eopts.GroupBy
+		filter.groups = make(map[string]string)
+	}
+	if len(eopts.Fields) > 0 {
+		filter.fields = make(map[string]bool)
+		for _, field := range eopts.Fields {
+			filter.fields[field] = true
+		}
+	}
+	return filter
+}
+
+// Remove duplicate URLs according to the options
+func (eopts ExtractOptions) dedupe(urls []string) []string {
+	if eopts.IgnoreQueryInDedup {
+		return dedupeURLsBy(urls, stripQuery)
+	}
+	return dedupeURLs(urls)
+}
+
+// Where extraction progress is printed. The extract subcommand moves it to
+// stderr so that stdout only carries URLs.
+var statusOut io.Writer = os.Stdout
+
+// Read one JSON input and extract its deduplicated image links.
+// Also returns the name used for the input's output directory.
+// Links found in an input
+type extractedURLs struct {
+	URLs   []string
+	Names  map[string]string // Filenames from NameField, keyed by URL (nil without it)
+	Groups map[string]string // Subdirectories from GroupBy, keyed by URL (nil without it)
+	Found  int               // Links found before deduplication and filtering
+	Input  string            // Input name used for the output directory
+
+	Sources map[string][]string // Inputs referencing each URL, set by -merge
+}
+
+func readImageURLs(client Doer, path, inlineJSON, jsonEnv string, eopts ExtractOptions) (extractedURLs, error) {
+	// Fetch API pages, or read and parse the JSON input
+	var docs []interface{}
+	var name string
+	if isURLInput(path) && inlineJSON == "" && jsonEnv == "" {
+		pages, err := fetchPages(client, path, eopts)
+		if err != nil {
+			return extractedURLs{}, fmt.Errorf("failed to fetch input: %v", err)
+		}
+		docs, name = pages, urlInputName(path)
+	} else {
+		jsonData, inputName, err := readJSONInput(path, inlineJSON, jsonEnv)
+		if err != nil {
+			return extractedURLs{}, fmt.Errorf("failed to read input: %v", err)
+		}
+
+		var data interface{}
+		if isCSVInput(path) && inlineJSON == "" && jsonEnv == "" {
+			rows, column, err := parseCSV(jsonData, eopts.CSVColumn)
+			if err != nil {
+				return extractedURLs{}, fmt.Errorf("failed to parse CSV: %v", err)
+			}
+			if column != "" {
+				eopts.Fields = []string{column}
+			}
+			data = rows
+		} else {
+			// Tolerate comments and trailing commas
+			if eopts.Lenient {
+				jsonData = stripLenientJSON(jsonData)
+			}
+
+			data, err = parseJSON(jsonData)
+			if err != nil {
+				return extractedURLs{}, fmt.Errorf("failed to parse JSON: %v", err)
+			}
+		}
+		docs, name = []interface{}{data}, inputName
+	}
+
+	var imageURLs []string
+	filter := eopts.filter()
+
+	// Relative rich-text links of API responses point into the API's site
+	if eopts.ParseHTML && eopts.BaseURL == "" && isURLInput(path) && inlineJSON == "" && jsonEnv == "" {
+		filter.baseURL, _ = url.Parse(path)
+	}
+	var sniffedBefore, imagesBefore int
+	if eopts.Sniff {
+		if eopts.sniffed == nil {
+			eopts.sniffed = newSniffCache()
+		}
+		sniffedBefore, imagesBefore = eopts.sniffed.counts()
+		filter.sniff = func(u string) bool {
+			return eopts.sniffed.isImage(client, u)
+		}
+	}
+	for _, data := range docs {
+		var err error
+
+		// Only scan the data of GraphQL responses
+		if eopts.GraphQL {
+			data, err = graphQLData(data)
+			if err != nil {
+				return extractedURLs{}, err
+			}
+		}
+
+		// Only scan the selected subtree
+		data, err = resolveJSONPointer(data, eopts.Pointer)
+		if err != nil {
+			return extractedURLs{}, err
+		}
+
+		// Extract all image URLs, taking a plain array of links as is
+		if links, ok := urlArrayLinks(data, filter); ok {
+			fmt.Fprintf(statusOut, "Input is an array of %d URLs, taking each as an image link\n", len(data.([]interface{})))
+			imageURLs = append(imageURLs, links...)
+		} else if eopts.URLArray {
+			return extractedURLs{}, fmt.Errorf("-json-array-of-urls: input isn't a top-level array of http(s) URL strings")
+		} else if items, ok := data.([]interface{}); ok && eopts.Workers > 1 {
+			imageURLs = append(imageURLs, extractImageURLsParallel(items, eopts.Workers, filter)...)
+		} else {
+			extractImageURLs(data, filter, &imageURLs)
+		}
+
+		// Scan JSON documents linked from this one
+		if eopts.FollowRefs {
+			imageURLs = append(imageURLs, followJSONRefs(client, data, eopts.FollowDepth, eopts.MaxRefs, filter)...)
+		}
+	}
+
+	if filter != nil {
+		if n := filter.tooLong.Load(); n > 0 {
+			fmt.Fprintf(statusOut, "Skipped %d strings longer than %d characters (see -max-url-length)\n", n, eopts.MaxURLLength)
+		}
+		if n := filter.relative.Load(); n > 0 {
+			fmt.Fprintf(statusOut, "Skipped %d relative links in HTML or Markdown (set -base-url to resolve them)\n", n)
+		}
+		if n := filter.capped.Load(); n > 0 {
+			fmt.Fprintf(statusOut, "Capped %d strings at %d links each (see -max-urls-per-value)\n", n, eopts.MaxURLsPerValue)
+		}
+	}
+	if eopts.Sniff {
+		sniffed, images := eopts.sniffed.counts()
+		if sniffed > sniffedBefore {
+			fmt.Fprintf(statusOut, "Sniffed %d extensionless links, %d are images\n", sniffed-sniffedBefore, images-imagesBefore)
+		}
+	}
+
+	if len(imageURLs) == 0 {
+		fmt.Fprintln(statusOut, "No image links found")
+		return extractedURLs{Input: name}, nil
+	}
+
+	var names, groups map[string]string
+	if filter != nil {
+		names, groups = filter.names, filter.groups
+	}
+
+	fmt.Fprintf(statusOut, "Found %d image links\n", len(imageURLs))
+	found := len(imageURLs)
+	imageURLs = eopts.cleanURLs(imageURLs, names, groups)
+	return extractedURLs{URLs: imageURLs, Names: uniqueNames(imageURLs, names), Groups: groupDirs(imageURLs, groups), Found: found, Input: name}, nil
+}
+
+// Deduplicate, transform and check the scheme of found links, moving
+// their chosen names and groups along with rewritten URLs
+func (eopts ExtractOptions) cleanURLs(imageURLs []string, names, groups map[string]string) []string {
+	// Remove duplicate links
+	foundCount := len(imageURLs)
+	imageURLs = eopts.dedupe(imageURLs)
+	if len(imageURLs) < foundCount {
+		fmt.Fprintf(statusOut, "Removed %d duplicate links, %d unique\n", foundCount-len(imageURLs), len(imageURLs))
+	}
+
+	// Rewrite URLs, e.g. thumbnails to full-size images
+	if len(eopts.Transforms) > 0 {
+		before := append([]string(nil), imageURLs...)
+		changed := transformURLs(imageURLs, eopts.Transforms)
+		carryNames(names, before, imageURLs)
+		carryNames(groups, before, imageURLs)
+		fmt.Fprintf(statusOut, "Transformed %d links\n", changed)
+
+		// Different URLs may now be the same
+		transformedCount := len(imageURLs)
+		imageURLs = eopts.dedupe(imageURLs)
+		if len(imageURLs) < transformedCount {
+			fmt.Fprintf(statusOut, "Removed %d duplicate links after transforming, %d unique\n", transformedCount-len(imageURLs), len(imageURLs))
+		}
+	}
+
+	// Handle mixed-content links
+	before := append([]string(nil), imageURLs...)
+	imageURLs = filterInsecureURLs(imageURLs, eopts.HTTPSOnly, eopts.UpgradeInsecure)
+	carryNames(names, before, imageURLs)
+	carryNames(groups, before, imageURLs)
+	return eopts.dedupe(imageURLs)
+}
+
+// Skip or upgrade plain http:// links, reporting how many were affected
+func filterInsecureURLs(imageURLs []string, httpsOnly, upgrade bool) []string {
+	insecure := 0
+	for _, imageURL := range imageURLs {
+		if isInsecureURL(imageURL) {
+			insecure++
+		}
+	}
+	if insecure == 0 {
+		return imageURLs
+	}
+
+	switch {
+	case upgrade:
+		for i, imageURL := range imageURLs {
+			if isInsecureURL(imageURL) {
+				imageURLs[i] = "https://" + imageURL[len("http://"):]
+			}
+		}
+		fmt.Fprintf(statusOut, "Upgraded %d insecure http:// links to https://\n", insecure)
+		return imageURLs
+	case httpsOnly:
+		secure := imageURLs[:0]
+		for _, imageURL := range imageURLs {
+			if !isInsecureURL(imageURL) {
+				secure = append(secure, imageURL)
+			}
+		}
+		fmt.Fprintf(statusOut, "Skipped %d insecure http:// links\n", insecure)
+		return secure
+	default:
+		fmt.Fprintf(statusOut, "Warning: %d links use insecure http:// (see -https-only and -upgrade-insecure)\n", insecure)
+		return imageURLs
+	}
+}
+
+func isInsecureURL(imageURL string) bool {
+	return len(imageURL) > len("http://") && strings.EqualFold(imageURL[:len("http://")], "http://")
+}
+
+// Download the images of one input into ~/Downloads/<name>, or below
+// prefix/<name> when an S3 store is given
+func downloadInput(client Doer, imageURLs []string, name string, opts Options, store *s3Store) error {
+	var sink Sink
+	if store != nil {
+		sink = store.sink(name)
+		fmt.Printf("Output location: %s\n", sink.Location(""))
+	} else {
+		// Get Download directory
+		downloadDir, err := getDownloadDir()
+		if err != nil {
+			return fmt.Errorf("failed to get Download directory: %v", err)
+		}
+
+		// Create output directory
+		outputDir := filepath.Join(downloadDir, name)
+		err = opts.modes().mkdirAll(outputDir)
+		if err != nil {
+			return fmt.Errorf("failed to create directory: %v", err)
+		}
+
+		sink = newDirSink(outputDir, opts.modes())
+		fmt.Printf("Output directory: %s\n", outputDir)
+	}
+	if opts.Layout != layoutFlat {
+		fmt.Printf("Output layout: %s\n", opts.Layout)
+	}
+	if opts.LimitMB > 0 || len(opts.ExtLimits) > 0 {
+		limits := limitFlag{defaultMB: opts.LimitMB, byExt: opts.ExtLimits}
+		fmt.Printf("Image size limit: %s\n", limits.describe())
+	} else {
+		fmt.Println("No size limit, downloading original images")
+	}
+	fmt.Println("Downloading images...")
+
+	// Download all images
+	downloadAll(client, imageURLs, sink, opts)
+	return nil
+}
+
+// Convert a -timeout-retries or -status-retries value, where -1 means the
+// same as -retries, to the Options convention, where that is 0
+func flagRetries(n int) int {
+	switch {
+	case n < 0:
+		return 0
+	case n == 0:
+		return -1
+	}
+	return n
+}
+
+// Print command line usage
+func printUsage() {
+	fmt.Println("Usage: json-shake [download] [options] <json-file-path|csv-file-path|api-url>...")
+	fmt.Println("       json-shake [download] [options] -json '<json>'")
+	fmt.Println("       json-shake [download] [options] -json-env <VARNAME>")
+	fmt.Println("       json-shake [download] [options] -url-list <file>")
+	fmt.Println("       json-shake extract [options] <json-file-path|api-url>...")
+	fmt.Println("       json-shake compress [options] <dir>")
+	fmt.Println("       json-shake -version")
+	fmt.Println("Commands:")
+	fmt.Println("  download             Download images from JSON (default)")
+	fmt.Println("  extract              Print image URLs found in JSON without downloading")
+	fmt.Println("  compress             Recompress an existing directory of images to a limit")
+	fmt.Println("  selftest             Check the link extractor against built-in JSON samples")
+	fmt.Println("Run 'json-shake <command> -h' for the options of extract, compress and selftest.")
+	fmt.Println("Download options:")
+	fmt.Println("  -config <file>       Load options from a JSON file; command line flags take precedence")
+	fmt.Println("  -limit <MB>          Maximum image size in MB (default: 0, no compression)")
+	fmt.Println("                       Per extension: -limit jpg=1,png=2 or with a default: -limit 1,png=2")
+	fmt.Println("  -cookie <name=value> Cookie sent with every image request (repeatable)")
+	fmt.Println("  -cookie-jar <file>   Load cookies from a Netscape-format cookie file")
+	fmt.Println("  -output-layout <l>   Output layout: flat, by-host or by-ext (default: flat)")
+	fmt.Println("  -dir-mode <mode>     Octal permissions of created output directories, e.g. 0700")
+	fmt.Println("  -file-mode <mode>    Octal permissions of saved files, e.g. 0600")
+	fmt.Println("  -s3 <s3://bucket/prefix>")
+	fmt.Println("                       Upload images to an S3-compatible bucket instead of ~/Downloads")
+	fmt.Println("  -s3-endpoint <url>   Custom S3 endpoint, e.g. http://localhost:9000 for MinIO")
+	fmt.Println("  -s3-region <region>  S3 region (default: $AWS_REGION or us-east-1)")
+	fmt.Println("  -json <json>         Read JSON from the given string instead of a file")
+	fmt.Println("  -json-env <VARNAME>  Read JSON from an environment variable")
+	fmt.Println("  -total-limit <MB>    Recompress the largest images until all images fit in this size")
+	fmt.Println("  -perceptual-dedup    Delete near-duplicate images after downloading, keeping the highest resolution")
+	fmt.Println("  -perceptual-threshold <n>")
+	fmt.Println("                       Maximum differing bits of the image hashes, 0-64 (default: 5)")
+	fmt.Println("  -bandwidth <KB/s>    Maximum download rate (default: 0, unlimited)")
+	fmt.Println("  -pipeline            Download, compress and write images in parallel stages")
+	fmt.Println("  -download-workers <n>")
+	fmt.Println("                       Concurrent downloads with -pipeline (default: 4)")
+	fmt.Println("  -compress-workers <n>")
+	fmt.Println("                       Images compressed in parallel with -pipeline (default: number of CPUs)")
+	fmt.Println("  -write-workers <n>   Images written in parallel with -pipeline (default: 2)")
+	fmt.Println("  -pipeline-buffer <n> Images queued between -pipeline stages (default: 8)")
+	fmt.Println("  -retries <n>         Retry failed downloads this many times (network errors, 429 and 5xx)")
+	fmt.Println("  -timeout-retries <n> Retry timeouts this many times instead (default: same as -retries)")
+	fmt.Println("  -status-retries <n>  Retry 429 and 5xx responses this many times instead (default: same as -retries)")
+	fmt.Println("  -mirror <h=m1,m2>    Fallback hosts tried when a host fails (repeatable)")
+	fmt.Println("  -max-retries-per-host <n>")
+	fmt.Println("                       Total retries allowed per host, then its images fail without retrying")
+	fmt.Println("  -skip-hosts-on-failure-threshold <n>")
+	fmt.Println("                       Skip a host's remaining images after n consecutive failures")
+	fmt.Println("  -respect-robots      Skip images disallowed by their host's robots.txt")
+	fmt.Println("  -auth-command <cmd>  Command whose output is sent as the Authorization header")
+	fmt.Println("  -auth-command-ttl <duration>")
+	fmt.Println("                       Reuse the -auth-command output for this long (default: 1m)")
+	fmt.Println("  -host-auth <h=auth;...>")
+	fmt.Println("                       Authorization header per host, e.g. cdn1.com=Bearer T1;cdn2.com=Basic dXNlcjpwdw==")
+	fmt.Println("  -host-header <host>  Host header sent with image requests instead of the URL's host")
+	fmt.Println("  -dns-server <host>   DNS server used to resolve hosts, as host[:port] (default: system resolver)")
+	fmt.Println("  -doh-url <url>       DNS-over-HTTPS endpoint used to resolve hosts")
+	fmt.Println("  -force-http1         Use HTTP/1.1 only, for servers whose HTTP/2 support hangs or resets")
+	fmt.Println("  -trace               Print DNS, connect, TLS and time-to-first-byte durations of every request")
+	fmt.Println("  -record <file>       Log every request (method, URL, headers without secrets, status, bytes) as JSON Lines")
+	fmt.Println("  -replay <file>       Send the requests of a -record log again and report differences, instead of downloading")
+	fmt.Println("  -since <date>        Skip images not modified after this date (Last-Modified), e.g. 2024-05-01")
+	fmt.Println("  -since-missing <p>   With -since, images without Last-Modified: download or skip (default: download)")
+	fmt.Println("  -min-bytes <n>       Skip images smaller than this many bytes (e.g. tracking pixels)")
+	fmt.Println("  -fix-extensions      Rename files whose extension doesn't match the image format")
+	fmt.Println("  -name-field <key>    Name each image after this field of its enclosing JSON object, e.g. id or title")
+	fmt.Println("  -group-by <key>      Save each image to a subdirectory named after this field of its enclosing JSON object")
+	fmt.Println("  -strip-metadata      Remove EXIF, GPS, XMP and comment metadata from saved images")
+	fmt.Println("  -strict-validate     Fully decode images and reject corrupt or truncated files and non-images")
+	fmt.Println("  -verify-length       Fail downloads whose size differs from their Content-Length (truncated responses)")
+	fmt.Println("  -sniff-bytes <n>     Bytes inspected to detect the image format of a download (default: 1024)")
+	fmt.Println("  -convert-to <format> Re-encode every image to jpg, png or gif, regardless of size")
+	fmt.Println("  -max-megapixels <mp> Downscale images over this many megapixels, keeping aspect ratio, before -limit")
+	fmt.Println("  -emit-variants <v1,v2>")
+	fmt.Println("                       Also write these variants of each image to subdirectories: original, thumbnail, jpg, png, gif")
+	fmt.Println("  -shard-size <n>      Put at most n images into each part-001, part-002, ... subdirectory (default: 0, off)")
+	fmt.Println("  -max-filename-index-width <n>")
+	fmt.Println("                       Zero-pad indices like image_007 by the URL count, up to n digits (default: 6, 0 = off)")
+	fmt.Println("  -prefix-index-by-file")
+	fmt.Println("                       Prefix index-based fallback filenames with the input's name, e.g. data_image_007")
+	fmt.Println("  -resume              Resume interrupted downloads from .part files")
+	fmt.Println("  -sidecar             Write <image>.json next to each image with its source URL, sizes and changes made")
+	fmt.Println("  -save-headers        Write <image>.headers next to each image with its full HTTP response headers")
+	fmt.Println("  -rename-existing     Keep both files when a different image has the same name, e.g. photo-1a2b3c4d.jpg")
+	fmt.Println("  -compress-formats <f1,f2>")
+	fmt.Println("                       Only compress these formats, e.g. jpg,webp; others are kept as downloaded (default: all)")
+	fmt.Println("  -compress-if-over-ratio <r>")
+	fmt.Println("                       Only compress images larger than limit times r (e.g. 1.05)")
+	fmt.Println("  -jpeg-quality <q>    JPEG quality tried first when compressing (default: 85 down to 25)")
+	fmt.Println("  -post-sharpen        Sharpen images compressed at JPEG quality 45 or below (slower)")
+	fmt.Println("  -preserve-original   Also keep the uncompressed download of compressed images in originals/")
+	fmt.Println("  -watch               Keep running and download new images whenever an input file changes")
+	fmt.Println("  -watch-interval <d>  How often -watch checks the input files (default: 1s)")
+	fmt.Println("  -merge               Download the unique images of all inputs once, into a shared merged directory")
+	fmt.Println("  -head-only           Only send HEAD requests and record status, type, size and date in -manifest")
+	fmt.Println("  -estimate            Print the total and per-host size reported by HEAD requests, then stop (or ask with -confirm)")
+	fmt.Println("  -dry-run             Print where each image would be saved and warn about filename collisions")
+	fmt.Println("  -list-only <file>    Write extracted URLs to a file, one per line, without downloading")
+	fmt.Println("  -dedupe-report       Print link counts, duplicates, per-host/format breakdowns and naming collisions, then stop")
+	fmt.Println("  -dedupe-report-json <file>")
+	fmt.Println("                       Also write the -dedupe-report of every input to a JSON file (implies -dedupe-report)")
+	fmt.Println("  -url-list <file>     Download the URLs of a text file, one per line, instead of JSON inputs")
+	fmt.Println("  -pointer <ptr>       Only scan the subtree at this JSON Pointer, e.g. /products/0/images")
+	fmt.Println("  -graphql             Only scan the data of a GraphQL response and report its errors")
+	fmt.Println("  -lenient             Accept // and /* */ comments and trailing commas in the input")
+	fmt.Println("  -where <key=value>   Only extract from objects whose field matches, also key!=value (repeatable)")
+	fmt.Println("  -field <k1,k2>       Only extract values stored under these JSON keys, e.g. imageUrl,thumbnailUrl")
+	fmt.Println("  -srcset-prefer <p>   Candidates taken from srcset values: all or largest (default: all)")
+	fmt.Println("  -max-url-length <n>  Skip strings longer than n characters instead of scanning them (default: 2048, 0 = off)")
+	fmt.Println("  -max-urls-per-value <n>")
+	fmt.Println("                       Take at most n links from a single JSON string (default: 0 = no limit)")
+	fmt.Println("  -parse-html          Also take images from HTML <img>/<source> tags and Markdown ![](url) in strings")
+	fmt.Println("  -base-url <url>      Resolve relative links found by -parse-html against this URL")
+	fmt.Println("  -csv-column <c>      Only scan this column of .csv inputs, by header name or number")
+	fmt.Println("  -https-only          Skip image links that use plain http://")
+	fmt.Println("  -upgrade-insecure    Rewrite plain http:// image links to https://")
+	fmt.Println("  -ignore-query-in-dedup")
+	fmt.Println("                       Treat URLs that differ only in the query string as duplicates")
+	fmt.Println("  -sniff               Fetch the first bytes of extensionless links to find images among them")
+	fmt.Println("  -json-array-of-urls  Require inputs to be a top-level array of URL strings, each taken as an image")
+	fmt.Println("  -extra-content-type <type=.ext>")
+	fmt.Println("                       Treat this Content-Type as an image, e.g. image/heic=.heic (repeatable)")
+	fmt.Println("  -transform-url <s/re/repl/[g]>")
+	fmt.Println("                       Rewrite each extracted URL with a sed-style substitution (repeatable)")
+	fmt.Println("  -confirm             Ask before downloading more images than -confirm-threshold")
+	fmt.Println("  -confirm-threshold <n>")
+	fmt.Println("                       Number of images above which -confirm asks (default: 500)")
+	fmt.Println("  -yes                 Answer yes to -confirm; required when stdin is not a terminal")
+	fmt.Println("  -manifest <file>     Append a JSON line per download result as downloads complete")
+	fmt.Println("  -manifest-array <file>")
+	fmt.Println("                       After the run, convert the -manifest records into a JSON array file")
+	fmt.Println("  -contact-sheet <file>")
+	fmt.Println("                       Write a thumbnail grid of all downloaded images (PNG or JPEG)")
+	fmt.Println("  -contact-sheet-columns <n>")
+	fmt.Println("                       Number of contact sheet columns (default: 8)")
+	fmt.Println("  -contact-sheet-cell <px>")
+	fmt.Println("                       Size of each contact sheet cell in pixels (default: 160)")
+	fmt.Println("  -metrics <file>      Write Prometheus-format metrics to a file after the run")
+	fmt.Println("  -metrics-port <port> Serve Prometheus metrics at 127.0.0.1:<port>/metrics during the run")
+	fmt.Println("  -metrics-addr <host:port>")
+	fmt.Println("                       Serve them on this address instead, e.g. 0.0.0.0:9090 for all interfaces")
+	fmt.Println("  -summary <file>      Write a JSON summary with counts, bytes, duration and per-host/format breakdowns")
+	fmt.Println("  -no-color            Disable colored output (also with the NO_COLOR environment variable)")
+	fmt.Println("  -list-formats        Print supported image decoders and content types")
+	fmt.Println("  -cursor-field <f>    Field or JSON Pointer holding the next page cursor of API URL inputs")
+	fmt.Println("  -cursor-param <p>    Query parameter the cursor is sent in for the next page, e.g. after")
+	fmt.Println("  -max-pages <n>       Maximum pages fetched per API URL (default: 100)")
+	fmt.Println("  -extract-workers <n> Workers used to extract URLs from a top-level JSON array (default: 1)")
+	fmt.Println("  -follow-json-refs    Fetch JSON documents referenced by .json URLs and extract their images")
+	fmt.Println("  -follow-depth <n>    Maximum depth of followed JSON references (default: 2)")
+	fmt.Println("  -max-json-refs <n>   Maximum referenced JSON documents fetched per input (default: 100)")
+	fmt.Println("Example: json-shake data.json")
+	fmt.Println("Example: json-shake -limit 1 data.json")
+	fmt.Println("Example: json-shake -cookie-jar cookies.txt data.json")
+	fmt.Println(`Example: json-shake -json '{"avatar": "https://example.com/a.png"}'`)
+}
+
+// Magic bytes used to probe which image decoders are registered
+var decoderProbes = []struct {
+	name  string
+	magic string
+}{
+	{"jpeg", "\xff\xd8"},
+	{"png", "\x89PNG\r\n\x1a\n"},
+	{"gif", "GIF89a"},
+	{"bmp", "BM????\x00\x00\x00\x00"},
+	{"webp", "RIFF????WEBPVP8"},
+	{"tiff", "II*\x00"},
+}
+
+// List registered image decoders.
+// The image package doesn't expose its registry, so each known format is
+// probed with its magic bytes: unregistered formats fail with ErrFormat.
+func registeredDecoders() []string {
+	var names []string
+	for _, probe := range decoderProbes {
+		_, _, err := image.DecodeConfig(strings.NewReader(probe.magic))
+		if err != image.ErrFormat {
+			names = append(names, probe.name)
+		}
+	}
+	return names
+}
+
+// Print supported image decoders and content types
+func printFormats() {
+	fmt.Println("Image decoders (used for compression):")
+	for _, name := range registeredDecoders() {
+		fmt.Printf("  %s\n", name)
+	}
+
+	contentTypes := make([]string, 0, len(contentTypeExtensions))
+	for contentType := range contentTypeExtensions {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+
+	fmt.Println("Content types (used to infer missing extensions):")
+	for _, contentType := range contentTypes {
+		fmt.Printf("  %-14s %s\n", contentType, contentTypeExtensions[contentType])
+	}
+	if len(extraContentTypes) > 0 {
+		fmt.Println("Extra content types (-extra-content-type):")
+		for _, contentType := range sortedExtraContentTypes() {
+			fmt.Printf("  %-14s %s\n", contentType, extraContentTypes[contentType])
+		}
+	}
+}
+
+// Flag value that can be given multiple times
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Download the images of JSON inputs, the default subcommand. Returns
+// errRunFailed once output files are open, so they're closed before main
+// exits with status 1.
+func runDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	fs.Usage = printUsage
+
+	// Define command line flags
+	var in inputFlags
+	var limits limitFlag
+	var cookies stringListFlag
+	var cookieJarPath string
+	var outputLayout string
+	var totalLimitMB float64
+	var fixExtensions bool
+	var strictValidate bool
+	var verifyLength bool
+	var sniffBytes int
+	var trace bool
+	var forceHTTP1 bool
+	var recordPath string
+	var replayPath string
+	var merge bool
+	var stripMeta bool
+	var convertTo string
+	var maxMegapixels float64
+	var emitVariants string
+	var perceptualDedup bool
+	var perceptualThreshold int
+	var nameField string
+	var groupBy string
+	var maxIndexWidth int
+	var shardSize int
+	var prefixIndexByFile bool
+	var resume bool
+	var renameExisting bool
+	var writeSidecars bool
+	var saveHeaders bool
+	var listOnlyPath string
+	var urlListPath string
+	var jpegQuality int
+	var postSharpen bool
+	var listFormats bool
+	var minBytes int64
+	var hostHeader string
+	var retries int
+	var timeoutRetries int
+	var statusRetries int
+	var mirrorFlags stringListFlag
+	var hostFailureThreshold int
+	var maxRetriesPerHost int
+	var respectRobots bool
+	var metricsPath string
+	var summaryPath string
+	var metricsPort int
+	var metricsAddr string
+	var compressRatio float64
+	var compressFormats string
+	var authCmd string
+	var hostAuthFlag string
+	var authTTL time.Duration
+	var contactSheetPath string
+	var contactSheetColumns int
+	var contactSheetCell int
+	var s3Target string
+	var s3Endpoint string
+	var s3Region string
+	var dnsServer string
+	var dohURL string
+	var manifestPath string
+	var manifestArrayPath string
+	var confirm bool
+	var preserveOriginal bool
+	var dirModeFlag string
+	var dryRun bool
+	var dedupeReportMode bool
+	var dedupeReportPath string
+	var headOnly bool
+	var estimate bool
+	var bandwidthKB float64
+	var pipeline bool
+	var downloadWorkers int
+	var compressWorkers int
+	var writeWorkers int
+	var pipelineBuffer int
+	var sinceFlag string
+	var sinceMissing string
+	var watch bool
+	var noColor bool
+	var watchInterval time.Duration
+	var fileModeFlag string
+	var confirmThreshold int
+	var assumeYes bool
+	fs.Var(&limits, "limit", "Maximum image size in MB, optionally per extension like jpg=1,png=2 (0 = no limit)")
+	fs.Var(&cookies, "cookie", "Cookie sent with every image request, as name=value (repeatable)")
+	fs.StringVar(&cookieJarPath, "cookie-jar", "", "Load cookies from a Netscape-format cookie file")
+	fs.StringVar(&outputLayout, "output-layout", layoutFlat, "Output layout: flat, by-host or by-ext")
+	fs.StringVar(&dirModeFlag, "dir-mode", "", "Octal permissions of created output directories, e.g. 0700 (default: 0755 minus umask)")
+	fs.StringVar(&fileModeFlag, "file-mode", "", "Octal permissions of saved files, e.g. 0600 (default: 0644 minus umask)")
+	fs.StringVar(&s3Target, "s3", "", "Upload images to an S3-compatible bucket, as s3://bucket/prefix")
+	fs.StringVar(&s3Endpoint, "s3-endpoint", "", "Custom S3 endpoint URL (MinIO etc.), uses path-style requests")
+	fs.StringVar(&s3Region, "s3-region", "", "S3 region used for request signing (default: $AWS_REGION or us-east-1)")
+	fs.Float64Var(&totalLimitMB, "total-limit", 0, "Maximum total size of the output directory in MB (0 = no limit)")
+	fs.BoolVar(&preserveOriginal, "preserve-original", false, "Also keep the uncompressed download of compressed images in originals/")
+	fs.BoolVar(&fixExtensions, "fix-extensions", false, "Rename files whose extension doesn't match the image format")
+	fs.IntVar(&shardSize, "shard-size", 0, "Put at most this many images into each part-001, part-002, ... subdirectory of the output (0 = no shards)")
+	fs.IntVar(&maxIndexWidth, "max-filename-index-width", 6, "Zero-pad fallback filename indices to the URL count's digits, up to this width (0 = no padding)")
+	fs.BoolVar(&prefixIndexByFile, "prefix-index-by-file", false, "Prefix index-based fallback filenames with the input's name, e.g. data_image_007")
+	fs.StringVar(&nameField, "name-field", "", "Name each image after this field of its enclosing JSON object, e.g. id or title")
+	fs.StringVar(&groupBy, "group-by", "", "Save each image to a subdirectory named after this field of its enclosing JSON object, e.g. category")
+	fs.BoolVar(&perceptualDedup, "perceptual-dedup", false, "After downloading, delete near-duplicate images and keep the highest-resolution copy")
+	fs.IntVar(&perceptualThreshold, "perceptual-threshold", 5, "Maximum differing bits of the 64-bit image hashes for -perceptual-dedup")
+	fs.BoolVar(&forceHTTP1, "force-http1", false, "Disable HTTP/2 and use HTTP/1.1 for all requests, for servers that stall or reset HTTP/2 connections")
+	fs.BoolVar(&trace, "trace", false, "Print DNS, connect, TLS and time-to-first-byte durations of every request")
+	fs.StringVar(&recordPath, "record", "", "Log every HTTP request (method, URL, headers without secrets, status, bytes) to this JSON Lines file")
+	fs.StringVar(&replayPath, "replay", "", "Send the requests of a -record log again and report where status or size differ, instead of downloading")
+	fs.BoolVar(&stripMeta, "strip-metadata", false, "Remove EXIF, GPS, XMP and comment metadata from saved images")
+	fs.StringVar(&emitVariants, "emit-variants", "", "Also write these comma-separated variants of each image to subdirectories: original, thumbnail, jpg, png or gif")
+	fs.StringVar(&convertTo, "convert-to", "", "Re-encode every image to this format regardless of size: jpg, png or gif")
+	fs.Float64Var(&maxMegapixels, "max-megapixels", 0, "Downscale images with more than this many million pixels, keeping their aspect ratio, before size compression (0 = keep size)")
+	fs.BoolVar(&strictValidate, "strict-validate", false, "Fully decode downloaded images and reject corrupt or truncated files and non-images")
+	fs.BoolVar(&verifyLength, "verify-length", false, "Fail downloads whose received size differs from their Content-Length, before writing them")
+	fs.IntVar(&sniffBytes, "sniff-bytes", defaultSniffBytes, "Bytes inspected to detect the image format of a download")
+	fs.BoolVar(&resume, "resume", false, "Resume interrupted downloads from .part files")
+	fs.BoolVar(&saveHeaders, "save-headers", false, "Write <image>.headers next to each downloaded image with the status line, headers and trailers of its response")
+	fs.BoolVar(&writeSidecars, "sidecar", false, "Write <image>.json next to each downloaded image with its URL, time, Content-Type, sizes and changes")
+	fs.BoolVar(&renameExisting, "rename-existing", false, "Download images whose filename is taken and keep both files if they differ, adding a content hash to the new name")
+	fs.StringVar(&compressFormats, "compress-formats", "", "Only compress images in these comma-separated formats, e.g. jpg,webp, and keep others as downloaded even over the limit")
+	fs.Float64Var(&compressRatio, "compress-if-over-ratio", 1, "Only compress images larger than limit times this ratio (e.g. 1.05)")
+	fs.IntVar(&jpegQuality, "jpeg-quality", 0, "JPEG quality used when compressing, 1-100 (0 = try 85 down to 25)")
+	fs.BoolVar(&postSharpen, "post-sharpen", false, "Sharpen images compressed at JPEG quality 45 or below to reduce blur")
+	fs.BoolVar(&watch, "watch", false, "Keep running and download new images whenever an input file changes")
+	fs.DurationVar(&watchInterval, "watch-interval", time.Second, "How often -watch checks the input files for changes")
+	fs.BoolVar(&merge, "merge", false, "Combine the links of all inputs and download each unique image once into a shared merged directory")
+	fs.BoolVar(&estimate, "estimate", false, "Print the download size reported by HEAD requests, in total and per host, then stop unless -confirm is given")
+	fs.BoolVar(&headOnly, "head-only", false, "Only send HEAD requests and record status, type, size and Last-Modified in the -manifest")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print where each image would be saved and warn about filename collisions, without downloading")
+	fs.StringVar(&listOnlyPath, "list-only", "", "Write extracted URLs to a file, one per line, without downloading")
+	fs.BoolVar(&dedupeReportMode, "dedupe-report", false, "Print found, unique and removed link counts, per-host and per-format breakdowns and naming collisions, without downloading")
+	fs.StringVar(&dedupeReportPath, "dedupe-report-json", "", "Write the -dedupe-report of every input to this JSON file (implies -dedupe-report)")
+	fs.StringVar(&urlListPath, "url-list", "", "Download the URLs of a text file, one per line (# comments allowed), instead of JSON inputs")
+	fs.Float64Var(&bandwidthKB, "bandwidth", 0, "Maximum download rate in KB/s (0 = unlimited)")
+	fs.BoolVar(&pipeline, "pipeline", false, "Download, compress and write images in parallel stages instead of one image at a time")
+	fs.IntVar(&downloadWorkers, "download-workers", 4, "Concurrent downloads with -pipeline")
+	fs.IntVar(&compressWorkers, "compress-workers", runtime.NumCPU(), "Images compressed and converted in parallel with -pipeline")
+	fs.IntVar(&writeWorkers, "write-workers", 2, "Images written in parallel with -pipeline")
+	fs.IntVar(&pipelineBuffer, "pipeline-buffer", defaultPipelineBuffer, "Images queued between two -pipeline stages before the earlier stage waits")
+	fs.IntVar(&retries, "retries", 0, "Retry failed downloads this many times (network errors, 429 and 5xx)")
+	fs.IntVar(&timeoutRetries, "timeout-retries", -1, "Retry timeouts this many times instead of -retries (-1 = same as -retries)")
+	fs.IntVar(&statusRetries, "status-retries", -1, "Retry 429 and 5xx responses this many times instead of -retries (-1 = same as -retries)")
+	fs.Var(&mirrorFlags, "mirror", "Fallback hosts for a failing host, as host=mirror1,mirror2 (repeatable)")
+	fs.BoolVar(&respectRobots, "respect-robots", false, "Fetch each host's robots.txt and skip images it disallows")
+	fs.IntVar(&maxRetriesPerHost, "max-retries-per-host", 0, "Total retries allowed per host before its images fail fast (0 = unlimited)")
+	fs.IntVar(&hostFailureThreshold, "skip-hosts-on-failure-threshold", 0, "Skip a host's remaining images after this many consecutive failures (0 = never)")
+	fs.StringVar(&contactSheetPath, "contact-sheet", "", "Write a grid of thumbnails of all downloaded images to this PNG/JPEG file")
+	fs.IntVar(&contactSheetColumns, "contact-sheet-columns", 8, "Number of columns in the contact sheet")
+	fs.IntVar(&contactSheetCell, "contact-sheet-cell", 160, "Size of each contact sheet cell in pixels")
+	fs.BoolVar(&confirm, "confirm", false, "Ask before downloading more images than -confirm-threshold")
+	fs.IntVar(&confirmThreshold, "confirm-threshold", 500, "Number of images above which -confirm asks before downloading")
+	fs.BoolVar(&assumeYes, "yes", false, "Answer yes to -confirm, required when stdin is not a terminal")
+	fs.StringVar(&manifestPath, "manifest", "", "Append a JSON line per download result to this file as downloads complete")
+	fs.StringVar(&manifestArrayPath, "manifest-array", "", "After the run, also write the -manifest records as a JSON array to this file")
+	fs.StringVar(&metricsPath, "metrics", "", "Write Prometheus-format metrics to a file after the run")
+	fs.StringVar(&summaryPath, "summary", "", "Write a JSON summary of the run with per-host and per-format counts to a file")
+	fs.IntVar(&metricsPort, "metrics-port", 0, "Serve Prometheus metrics on this port of 127.0.0.1 during the run (0 = off)")
+	fs.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this host:port during the run instead, e.g. 0.0.0.0:9090 for all interfaces")
+	fs.StringVar(&authCmd, "auth-command", "", "Command whose output is sent as the Authorization header")
+	fs.StringVar(&hostAuthFlag, "host-auth", "", "Authorization header per host, as host1=Bearer TOKEN;host2=Basic ...")
+	fs.DurationVar(&authTTL, "auth-command-ttl", time.Minute, "How long the -auth-command output is reused before running it again")
+	fs.StringVar(&dnsServer, "dns-server", "", "DNS server used to resolve hosts, as host[:port] (default: system resolver)")
+	fs.StringVar(&dohURL, "doh-url", "", "DNS-over-HTTPS endpoint used to resolve hosts, e.g. https://1.1.1.1/dns-query")
+	fs.StringVar(&hostHeader, "host-header", "", "Host header sent with image requests instead of the URL's host")
+	fs.StringVar(&sinceFlag, "since", "", "Skip images whose Last-Modified date is not after this date, e.g. 2024-05-01")
+	fs.StringVar(&sinceMissing, "since-missing", sinceMissingDownload, "With -since, what to do with images without Last-Modified: download or skip")
+	fs.Int64Var(&minBytes, "min-bytes", 0, "Skip images smaller than this many bytes (0 = keep all)")
+	fs.BoolVar(&noColor, "no-color", false, "Disable colored output (also with the NO_COLOR environment variable)")
+	fs.BoolVar(&listFormats, "list-formats", false, "Print supported image decoders and content types, then exit")
+	in.register(fs)
+	parseArgs(fs, args)
+	in.contentTypes.register()
+
+	if listFormats {
+		printFormats()
+		os.Exit(0)
+	}
+	enableColor(noColor)
+
+	// Check command line arguments
+	if fs.NArg() < 1 && in.inlineJSON == "" && in.jsonEnv == "" && urlListPath == "" && replayPath == "" {
+		printUsage()
+		os.Exit(1)
+	}
+
+	if urlListPath != "" {
+		if fs.NArg() > 0 || in.inlineJSON != "" || in.jsonEnv != "" {
+			fmt.Println("-url-list can't be combined with JSON inputs, -json or -json-env")
+			os.Exit(1)
+		}
+		if merge {
+			fmt.Println("-merge needs several JSON inputs, not -url-list")
+			os.Exit(1)
+		}
+	}
+
+	if replayPath != "" && (fs.NArg() > 0 || in.inlineJSON != "" || in.jsonEnv != "" || urlListPath != "") {
+		fmt.Println("-replay takes its requests from the log, not from inputs")
+		os.Exit(1)
+	}
+
+	if err := in.validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if contactSheetColumns < 1 || contactSheetCell < 1 {
+		fmt.Println("Contact sheet columns and cell size must be at least 1")
+		os.Exit(1)
+	}
+
+	if manifestArrayPath != "" && manifestPath == "" {
+		fmt.Println("-manifest-array requires -manifest")
+		os.Exit(1)
+	}
+
+	if dedupeReportPath != "" {
+		dedupeReportMode = true
+	}
+	if dedupeReportMode && (listOnlyPath != "" || dryRun || headOnly) {
+		fmt.Println("-dedupe-report can't be combined with -list-only, -dry-run or -head-only")
+		os.Exit(1)
+	}
+
+	if headOnly {
+		if manifestPath == "" {
+			fmt.Println("-head-only requires -manifest")
+			os.Exit(1)
+		}
+		if listOnlyPath != "" || dryRun {
+			fmt.Println("-head-only can't be combined with -list-only or -dry-run")
+			os.Exit(1)
+		}
+	}
+
+	var since time.Time
+	var err error
+	if sinceFlag != "" {
+		if since, err = parseSinceDate(sinceFlag); err != nil {
+			fmt.Printf("Invalid -since: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if sinceMissing != sinceMissingDownload && sinceMissing != sinceMissingSkip {
+		fmt.Printf("Invalid -since-missing: %s (expected download or skip)\n", sinceMissing)
+		os.Exit(1)
+	}
+
+	if watch {
+		if in.inlineJSON != "" || in.jsonEnv != "" {
+			fmt.Println("-watch needs input files, not -json or -json-env")
+			os.Exit(1)
+		}
+		if listOnlyPath != "" || dryRun || dedupeReportMode {
+			fmt.Println("-watch can't be combined with -list-only, -dry-run or -dedupe-report")
+			os.Exit(1)
+		}
+		if watchInterval <= 0 {
+			fmt.Println("-watch-interval must be positive")
+			os.Exit(1)
+		}
+	}
+
+	if bandwidthKB < 0 {
+		fmt.Printf("Invalid bandwidth: %g (expected 0 or more)\n", bandwidthKB)
+		os.Exit(1)
+	}
+
+	if downloadWorkers < 1 || compressWorkers < 1 || writeWorkers < 1 || pipelineBuffer < 1 {
+		fmt.Printf("Invalid pipeline size: %d/%d/%d workers, buffer %d (expected 1 or more each)\n", downloadWorkers, compressWorkers, writeWorkers, pipelineBuffer)
+		os.Exit(1)
+	}
+
+	if compressRatio < 1 {
+		fmt.Printf("Invalid compression ratio: %g (expected 1 or more)\n", compressRatio)
+		os.Exit(1)
+	}
+
+	if perceptualThreshold < 0 || perceptualThreshold > 64 {
+		fmt.Printf("Invalid perceptual threshold: %d (expected 0-64)\n", perceptualThreshold)
+		os.Exit(1)
+	}
+
+	if retries < 0 || timeoutRetries < -1 || statusRetries < -1 {
+		fmt.Printf("Invalid retry count: %d/%d/%d (expected 0 or more)\n", retries, timeoutRetries, statusRetries)
+		os.Exit(1)
+	}
+
+	if maxRetriesPerHost < 0 {
+		fmt.Printf("Invalid retry budget: %d (expected 0 or more)\n", maxRetriesPerHost)
+		os.Exit(1)
+	}
+
+	if maxIndexWidth < 0 {
+		fmt.Printf("Invalid filename index width: %d (expected 0 or more)\n", maxIndexWidth)
+		os.Exit(1)
+	}
+	if shardSize < 0 {
+		fmt.Printf("Invalid shard size: %d (expected 0 or more)\n", shardSize)
+		os.Exit(1)
+	}
+
+	if sniffBytes < minSniffBytes {
+		fmt.Printf("Invalid sniff byte count: %d (expected %d or more)\n", sniffBytes, minSniffBytes)
+		os.Exit(1)
+	}
+
+	if metricsPort < 0 || metricsPort > 65535 {
+		fmt.Printf("Invalid -metrics-port: %d (expected 1-65535)\n", metricsPort)
+		os.Exit(1)
+	}
+	if metricsAddr != "" {
+		if _, _, err := net.SplitHostPort(metricsAddr); err != nil {
+			fmt.Printf("Invalid -metrics-addr: %v (expected host:port)\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if maxMegapixels < 0 {
+		fmt.Printf("Invalid -max-megapixels: %g (expected 0 or more)\n", maxMegapixels)
+		os.Exit(1)
+	}
+	if jpegQuality < 0 || jpegQuality > 100 {
+		fmt.Printf("Invalid JPEG quality: %d (expected 1-100)\n", jpegQuality)
+		os.Exit(1)
+	}
+
+	formats, err := parseCompressFormats(compressFormats)
+	if err != nil {
+		fmt.Printf("Invalid -compress-formats: %v\n", err)
+		os.Exit(1)
+	}
+
+	variants, err := parseVariants(emitVariants)
+	if err != nil {
+		fmt.Printf("Invalid -emit-variants: %v\n", err)
+		os.Exit(1)
+	}
+
+	var convertExt string
+	if convertTo != "" {
+		if convertExt, err = parseConvertFormat(convertTo); err != nil {
+			fmt.Printf("Invalid -convert-to: %v\n", err)
+			os.Exit(1)
+		}
+		switch convertExt {
+		case ".jpg":
+			warnf("Warning: -convert-to %s is lossy; PNG and GIF sources lose detail and transparency", convertTo)
+		case ".gif":
+			warnf("Warning: -convert-to %s reduces every image to 256 colors", convertTo)
+		}
+	}
+
+	// Permissions for sensitive output on shared systems
+	var dirMode, fileMode os.FileMode
+	if dirModeFlag != "" {
+		if dirMode, err = parseFileMode(dirModeFlag); err != nil {
+			fmt.Printf("Invalid -dir-mode: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if fileModeFlag != "" {
+		if fileMode, err = parseFileMode(fileModeFlag); err != nil {
+			fmt.Printf("Invalid -file-mode: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	mirrors, err := parseMirrors(mirrorFlags)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Validate output layout
+	switch outputLayout {
+	case layoutFlat, layoutByHost, layoutByExt:
+	default:
+		fmt.Printf("Invalid output layout: %s (expected flat, by-host or by-ext)\n", outputLayout)
+		os.Exit(1)
+	}
+
+	// Upload to S3 instead of the local Downloads directory
+	var store *s3Store
+	if s3Target != "" {
+		store, err = newS3Store(s3Target, s3Endpoint, s3Region)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// These work on local files only
+		if resume {
+			warnf("Warning: -resume is ignored with -s3")
+		}
+		if totalLimitMB > 0 {
+			warnf("Warning: -total-limit is ignored with -s3")
+			totalLimitMB = 0
+		}
+		if contactSheetPath != "" {
+			warnf("Warning: -contact-sheet is ignored with -s3")
+			contactSheetPath = ""
+		}
+	}
+
+	// Build cookie jar for session-gated hosts
+	jar, err := newSessionJar(cookies, cookieJarPath)
+	if err != nil {
+		fmt.Printf("Failed to load cookies: %v\n", err)
+		os.Exit(1)
+	}
+	// Custom DNS for broken or restricted networks
+	resolver, err := newResolver(dnsServer, dohURL)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	client := newHTTPClient(jar, resolver)
+
+	// A throttled body can take longer than the overall timeout, so only
+	// bound the wait for response headers
+	if bandwidthKB > 0 {
+		transport, ok := client.Transport.(*http.Transport)
+		if !ok {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		transport.ResponseHeaderTimeout = client.Timeout
+		client.Transport = transport
+		client.Timeout = 0
+	}
+
+	// Work around servers and CDNs with broken HTTP/2: a non-nil, empty
+	// TLSNextProto keeps the transport from negotiating h2
+	if forceHTTP1 {
+		transport, ok := client.Transport.(*http.Transport)
+		if !ok {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		client.Transport = transport
+	}
+
+	// Print request timings for diagnosing slow or failing hosts
+	if trace {
+		client.Transport = newTracingTransport(client.Transport)
+	}
+
+	// Log requests for reproducing the run with -replay
+	if recordPath != "" {
+		recorder, err := newRecordingTransport(client.Transport, recordPath)
+		if err != nil {
+			fmt.Printf("Error creating request log: %v\n", err)
+			os.Exit(1)
+		}
+		defer recorder.close()
+		client.Transport = recorder
+	}
+
+	opts := Options{
+		LimitMB:              limits.defaultMB,
+		ExtLimits:            limits.byExt,
+		JPEGQuality:          jpegQuality,
+		PostSharpen:          postSharpen,
+		CompressRatio:        compressRatio,
+		CompressFormats:      formats,
+		Layout:               outputLayout,
+		PreserveOriginal:     preserveOriginal,
+		Bandwidth:            int64(bandwidthKB * 1024),
+		Since:                since,
+		SinceMissing:         sinceMissing,
+		DirMode:              dirMode,
+		FileMode:             fileMode,
+		Retries:              retries,
+		TimeoutRetries:       flagRetries(timeoutRetries),
+		StatusRetries:        flagRetries(statusRetries),
+		HostHeader:           hostHeader,
+		Mirrors:              mirrors,
+		HostFailureThreshold: hostFailureThreshold,
+		MaxRetriesPerHost:    maxRetriesPerHost,
+		RespectRobots:        respectRobots,
+		MinBytes:             minBytes,
+		FixExtensions:        fixExtensions,
+		StrictValidate:       strictValidate,
+		VerifyLength:         verifyLength,
+		SniffBytes:           sniffBytes,
+		StripMetadata:        stripMeta,
+		ConvertTo:            convertExt,
+		MaxMegapixels:        maxMegapixels,
+		Variants:             variants,
+		Resume:               resume,
+		RenameExisting:       renameExisting,
+		Sidecar:              writeSidecars,
+		SaveHeaders:          saveHeaders,
+		MaxIndexWidth:        maxIndexWidth,
+		ShardSize:            shardSize,
+	}
+
+	// Overlap downloading, compression and writing
+	if pipeline {
+		opts.DownloadWorkers = downloadWorkers
+		opts.CompressWorkers = compressWorkers
+		opts.WriteWorkers = writeWorkers
+		opts.PipelineBuffer = pipelineBuffer
+	}
+
+	// Fetch Authorization headers from an external command
+	if authCmd != "" {
+		opts.OnRequest = (&authCommand{command: authCmd, ttl: authTTL}).onRequest
+	}
+
+	// Per-host credentials take precedence over -auth-command
+	if hostAuthFlag != "" {
+		auth, err := parseHostAuth(hostAuthFlag)
+		if err != nil {
+			fmt.Println(err)
+			return errRunFailed
+		}
+		opts.OnRequest = auth.hook(opts.OnRequest)
+	}
+
+	// Re-run a recorded request log instead of downloading
+	if replayPath != "" {
+		differed, err := replayRequests(withRequestHook(client, opts.OnRequest), replayPath)
+		if err != nil {
+			fmt.Printf("Error replaying request log: %v\n", err)
+			return errRunFailed
+		}
+		if differed > 0 {
+			return errRunFailed
+		}
+		return nil
+	}
+
+	eopts := in.extractOptions()
+	eopts.NameField = nameField
+	eopts.GroupBy = groupBy
+
+	// Console progress output
+	metrics := newRunMetrics()
+	if metricsPort > 0 || metricsAddr != "" {
+		if err := metrics.serve(metricsListenAddr(metricsAddr, metricsPort)); err != nil {
+			fmt.Printf("Failed to serve metrics: %v\n", err)
+			return errRunFailed
+		}
+	}
+	var summary *runSummary
+	if summaryPath != "" {
+		summary = newRunSummary()
+	}
+	reporter := &consoleReporter{minBytes: minBytes, metrics: metrics, summary: summary, results: make(map[string]Result)}
+	opts.OnStart = reporter.onStart
+	opts.OnImage = reporter.onImage
+	opts.OnProgress = reporter.onProgress
+
+	// Record results as they complete
+	var manifest *manifestWriter
+	if manifestPath != "" {
+		manifest, err = openManifest(manifestPath)
+		if err != nil {
+			fmt.Println(err)
+			return errRunFailed
+		}
+		defer manifest.close()
+		opts.OnProgress = func(done, total int, result Result) {
+			reporter.onProgress(done, total, result)
+			manifest.record(result)
+		}
+	}
+
+	// Collect input files, expanding glob patterns
+	inputPaths, err := in.inputPaths(fs.Args())
+	if err != nil {
+		fmt.Println(err)
+		return errRunFailed
+	}
+	if urlListPath != "" {
+		inputPaths = []string{urlListPath}
+	}
+
+	// Process each input
+	var totals runStats
+	var listURLs []string
+	var dedupeReports []dedupeReport
+	var allSavedPaths []string
+	seenURLs := make(map[string]bool)
+
+	// Prefix fallback filenames with the input name, numbering repeated
+	// names like data-2, so inputs saved to the same directory don't collide
+	indexPrefixes := make(map[string]string)
+	prefixCounts := make(map[string]int)
+	prefixFor := func(inputPath, name string) string {
+		if !prefixIndexByFile {
+			return ""
+		}
+		if prefix, ok := indexPrefixes[inputPath]; ok {
+			return prefix
+		}
+		prefixCounts[name]++
+		prefix := name
+		if n := prefixCounts[name]; n > 1 {
+			prefix = fmt.Sprintf("%s-%d", name, n)
+		}
+		indexPrefixes[inputPath] = prefix
+		return prefix
+	}
+
+	// Download the images of one input. With onlyNew, images handled earlier
+	// in this run are left out.
+	readInput := func(inputPath string) (extractedURLs, error) {
+		return readImageURLs(withRequestHook(client, opts.OnRequest), inputPath, in.inlineJSON, in.jsonEnv, eopts)
+	}
+
+	// Take the URLs as listed, without extraction
+	if urlListPath != "" {
+		readInput = func(inputPath string) (extractedURLs, error) {
+			return readURLList(inputPath, eopts)
+		}
+	}
+
+	// Download the links of all inputs once, into a shared directory
+	runPaths := inputPaths
+	if merge {
+		readFile := readInput
+		readInput = func(string) (extractedURLs, error) {
+			return mergeInputs(inputPaths, eopts, readFile)
+		}
+		runPaths = []string{mergedInputName}
+	}
+
+	processInput := func(inputPath string, onlyNew bool) error {
+		extracted, err := readInput(inputPath)
+		if err != nil {
+			return err
+		}
+		imageURLs, outputName := extracted.URLs, extracted.Input
+		indexPrefix := prefixFor(inputPath, outputName)
+		planOpts := opts
+		planOpts.Groups = extracted.Groups
+
+		// Only report the shape of the links
+		if dedupeReportMode {
+			collisions := planOutputPaths(imageURLs, extracted.Names, planOpts.imageDir, opts.Layout, indexPrefix, indexWidth(len(imageURLs), opts.MaxIndexWidth), nil)
+			report := newDedupeReport(extracted, collisions)
+			report.print()
+			dedupeReports = append(dedupeReports, report)
+			return nil
+		}
+		if onlyNew {
+			var newURLs []string
+			for _, imageURL := range imageURLs {
+				if !seenURLs[imageURL] {
+					newURLs = append(newURLs, imageURL)
+				}
+			}
+			fmt.Printf("%d new image links\n", len(newURLs))
+			imageURLs = newURLs
+		}
+		if len(imageURLs) == 0 {
+			return nil
+		}
+
+		// Only collect the URL list for external tools
+		if listOnlyPath != "" {
+			listURLs = append(listURLs, imageURLs...)
+			return nil
+		}
+
+		// Only show the planned output files
+		if dryRun {
+			printDryRun(imageURLs, extracted.Names, planOpts.imageDir, opts.Layout, indexPrefix, indexWidth(len(imageURLs), opts.MaxIndexWidth))
+			return nil
+		}
+
+		// Only catalog the images with HEAD requests
+		if headOnly {
+			manifest.setInput(outputName, extracted.Sources)
+			checkImageHeads(withRequestHook(client, opts.OnRequest), imageURLs, opts, manifest)
+			for _, imageURL := range imageURLs {
+				seenURLs[imageURL] = true
+			}
+			return nil
+		}
+
+		// Show the expected size first, and only go on to ask with -confirm
+		if estimate {
+			estimateSizes(withRequestHook(client, opts.OnRequest), imageURLs, opts)
+			if !confirm {
+				return nil
+			}
+		}
+
+		// Guard against accidentally huge runs
+		if confirm {
+			ok, err := confirmDownload(len(imageURLs), confirmThreshold, assumeYes)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Println("Download cancelled")
+				return nil
+			}
+		}
+
+		reporter.reset()
+		if manifest != nil {
+			manifest.setInput(outputName, extracted.Sources)
+		}
+		inputOpts := opts
+		inputOpts.Names = extracted.Names
+		inputOpts.Groups = extracted.Groups
+		inputOpts.IndexPrefix = indexPrefix
+		if err := downloadInput(client, imageURLs, outputName, inputOpts, store); err != nil {
+			return err
+		}
+		for _, imageURL := range imageURLs {
+			seenURLs[imageURL] = true
+		}
+
+		// Output statistics
+		fmt.Println("\nDownload complete!")
+		reporter.stats.print()
+		totals.add(reporter.stats)
+
+		// Collapse rescaled and recompressed copies of the same picture
+		savedPaths := reporter.savedPaths
+		if perceptualDedup {
+			var removed []Result
+			savedPaths, removed = dedupePerceptual(savedPaths, reporter.results, perceptualThreshold)
+			for _, result := range removed {
+				if manifest != nil {
+					manifest.record(result)
+				}
+				summary.reclassify(reporter.results[result.Path], result)
+			}
+		}
+
+		// Fit all images into the total size budget
+		if totalLimitMB > 0 {
+			savedPaths = fitTotalLimit(savedPaths, totalLimitMB, jpegQuality, postSharpen, opts.CompressFormats, opts.modes())
+		}
+		allSavedPaths = append(allSavedPaths, savedPaths...)
+		return nil
+	}
+
+	failedInputs := 0
+	for _, inputPath := range runPaths {
+		if len(runPaths) > 1 {
+			fmt.Printf("\n=== %s ===\n", inputPath)
+		}
+
+		if err := processInput(inputPath, false); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			if len(runPaths) == 1 && !watch || errors.Is(err, errNeedsConfirmation) {
+				return errRunFailed
+			}
+			failedInputs++
+		}
+	}
+
+	// Download new images whenever an input file changes, until interrupted
+	if watch {
+		fmt.Println("\nWatching input files for changes, press Ctrl-C to stop...")
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		watchFiles(inputPaths, watchInterval, stop, func(inputPath string) {
+			fmt.Printf("\n=== %s changed ===\n", inputPath)
+			if err := processInput(inputPath, true); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		})
+		signal.Stop(stop)
+
+		fmt.Println("\nStopped watching.")
+		totals.print()
+	}
+
+	if dedupeReportPath != "" {
+		if err := writeDedupeReports(dedupeReportPath, dedupeReports); err != nil {
+			fmt.Printf("Failed to write dedupe report: %v\n", err)
+			return errRunFailed
+		}
+		fmt.Printf("\nWrote the dedupe report of %d inputs to %s\n", len(dedupeReports), dedupeReportPath)
+	}
+	if dedupeReportMode {
+		return nil
+	}
+
+	if listOnlyPath != "" {
+		listURLs = eopts.dedupe(listURLs)
+		if err := writeURLList(listOnlyPath, listURLs); err != nil {
+			fmt.Printf("Failed to write URL list: %v\n", err)
+			return errRunFailed
+		}
+		fmt.Printf("Wrote %d URLs to %s\n", len(listURLs), listOnlyPath)
+		return nil
+	}
+
+	// Aggregated statistics across input files
+	if len(runPaths) > 1 {
+		fmt.Printf("\nAll files complete! Files: %d, Failed files: %d\n", len(runPaths), failedInputs)
+		totals.print()
+	}
+
+	if contactSheetPath != "" {
+		if err := writeContactSheet(contactSheetPath, dedupeURLs(allSavedPaths), contactSheetColumns, contactSheetCell); err != nil {
+			fmt.Printf("Failed to write contact sheet: %v\n", err)
+		}
+	}
+
+	if manifestArrayPath != "" {
+		if err := convertManifest(manifestPath, manifestArrayPath); err != nil {
+			fmt.Printf("Failed to write manifest array: %v\n", err)
+		}
+	}
+
+	if metricsPath != "" {
+		if err := metrics.writeFile(metricsPath); err != nil {
+			fmt.Printf("Failed to write metrics: %v\n", err)
+		}
+	}
+
+	if summary != nil {
+		if err := summary.writeFile(summaryPath); err != nil {
+			fmt.Printf("Failed to write summary: %v\n", err)
+		}
+	}
+	return nil
+}
+
+func main() {
+	// Dispatch subcommands; without one, arguments are download options
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "extract":
+			runExtract(os.Args[2:])
+			return
+		case "download":
+			if runDownload(os.Args[2:]) != nil {
+				os.Exit(1)
+			}
+			return
+		case "compress":
+			runCompress(os.Args[2:])
+			return
+		case "selftest":
+			runSelftest(os.Args[2:])
+			return
+		case "version", "-version", "--version":
+			printVersion()
+			return
+		}
+	}
+	if runDownload(os.Args[1:]) != nil {
+		os.Exit(1)
+	}
 }
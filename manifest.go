@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// One line of the JSONL manifest
+type manifestEntry struct {
+	Input        string            `json:"input"`
+	Inputs       []string          `json:"inputs,omitempty"` // Inputs referencing the URL, with -merge
+	URL          string            `json:"url"`
+	Path         string            `json:"path,omitempty"`
+	Shard        string            `json:"shard,omitempty"` // Subdirectory of the image with -shard-size, like part-001
+	Size         int64             `json:"size,omitempty"`
+	OriginalPath string            `json:"original_path,omitempty"` // Uncompressed file kept by -preserve-original
+	Variants     map[string]string `json:"variants,omitempty"`      // Files written by -emit-variants, by variant
+	Status       string            `json:"status"`                  // downloaded, exists, too_small, not_modified, robots, circuit_open, corrupt, not_image, length_mismatch, near_duplicate, alive or failed
+	SkipReason   SkipReason        `json:"skip_reason,omitempty"`   // already_exists, too_small, filtered, blocked, not_image, robots_disallowed or near_duplicate
+	Error        string            `json:"error,omitempty"`
+	Time         time.Time         `json:"time"`
+
+	// Response headers recorded by -head-only
+	HTTPStatus    int    `json:"http_status,omitempty"`
+	ContentType   string `json:"content_type,omitempty"`
+	ContentLength *int64 `json:"content_length,omitempty"`
+	LastModified  string `json:"last_modified,omitempty"`
+}
+
+// Appends a JSON line per result as downloads complete, so records survive a
+// crash and the file can be followed while the run is in progress
+type manifestWriter struct {
+	mu      sync.Mutex
+	file    *os.File
+	input   string              // Name of the input being downloaded
+	sources map[string][]string // Inputs referencing each URL, with -merge
+	failed  bool                // A write failed and was reported
+}
+
+// Open a manifest for appending, keeping records of earlier runs
+func openManifest(path string) (*manifestWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %v", err)
+	}
+	return &manifestWriter{file: file}, nil
+}
+
+// Set the input name and URL sources recorded with the following results
+func (m *manifestWriter) setInput(name string, sources map[string][]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.input = name
+	m.sources = sources
+}
+
+func (m *manifestWriter) record(result Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := manifestEntry{
+		Input:        m.input,
+		Inputs:       m.sources[result.URL],
+		URL:          result.URL,
+		Path:         result.Path,
+		Size:         result.Size,
+		OriginalPath: result.OriginalPath,
+		Variants:     result.Variants,
+		Status:       resultStatus(result),
+		SkipReason:   result.SkipReason(),
+		Time:         time.Now().UTC(),
+	}
+	if result.Path != "" {
+		entry.Shard = result.Shard
+	}
+	if result.Err != nil && !errors.Is(result.Err, errAlreadyExists) {
+		entry.Error = result.Err.Error()
+	}
+
+	m.write(entry)
+}
+
+// Record the result of a -head-only check
+func (m *manifestWriter) recordHead(imageURL string, check headCheck) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := manifestEntry{
+		Input:        m.input,
+		Inputs:       m.sources[imageURL],
+		URL:          imageURL,
+		Status:       "alive",
+		Time:         time.Now().UTC(),
+		HTTPStatus:   check.StatusCode,
+		ContentType:  check.ContentType,
+		LastModified: check.LastModified,
+	}
+	if check.ContentLength >= 0 {
+		entry.ContentLength = &check.ContentLength
+	}
+	if check.Err != nil {
+		entry.Status = "failed"
+		entry.Error = check.Err.Error()
+	}
+	m.write(entry)
+}
+
+// Append an entry, reporting the first write failure. Called with mu held.
+func (m *manifestWriter) write(entry manifestEntry) {
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = m.file.Write(append(line, '\n'))
+	}
+	if err != nil && !m.failed {
+		warnf("Warning: failed to write manifest: %v", err)
+		m.failed = true
+	}
+}
+
+func (m *manifestWriter) close() error {
+	return m.file.Close()
+}
+
+// Manifest status of a download result
+func resultStatus(result Result) string {
+	switch {
+	case errors.Is(result.Err, errAlreadyExists):
+		return "exists"
+	case errors.Is(result.Err, errTooSmall):
+		return "too_small"
+	case errors.Is(result.Err, errNotModified):
+		return "not_modified"
+	case errors.Is(result.Err, errRobots):
+		return "robots"
+	case errors.Is(result.Err, errCircuitOpen):
+		return "circuit_open"
+	case errors.Is(result.Err, errCorruptImage):
+		return "corrupt"
+	case errors.Is(result.Err, errNotImage):
+		return "not_image"
+	case errors.Is(result.Err, errLengthMismatch):
+		return "length_mismatch"
+	case errors.Is(result.Err, errNearDuplicate):
+		return "near_duplicate"
+	case result.Err != nil:
+		return "failed"
+	default:
+		return "downloaded"
+	}
+}
+
+// Convert a JSONL manifest into a single JSON array file
+func convertManifest(jsonlPath, arrayPath string) error {
+	file, err := os.Open(jsonlPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries := []json.RawMessage{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			// A crash may leave a partial last line
+			continue
+		}
+		entries = append(entries, append(json.RawMessage(nil), line...))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(arrayPath, append(data, '\n'), 0644)
+}
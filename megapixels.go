@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// Scale width x height down to at most maxPixels pixels, preserving the
+// aspect ratio. Sizes within the budget are returned unchanged.
+func fitPixels(width, height int, maxPixels int64) (int, int) {
+	if width <= 0 || height <= 0 || int64(width)*int64(height) <= maxPixels {
+		return width, height
+	}
+	scale := math.Sqrt(float64(maxPixels) / (float64(width) * float64(height)))
+	return max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+}
+
+// Downscale an image to at most maxMegapixels million pixels for
+// -max-megapixels, keeping its aspect ratio and its format. Returns the data
+// unchanged if it's within the budget. JPEG, PNG and GIF can be resized;
+// animated images are kept, returning errAnimatedImage.
+func limitMegapixels(data []byte, maxMegapixels float64, jpegQuality int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image size: %v", err)
+	}
+	width, height := fitPixels(config.Width, config.Height, int64(maxMegapixels*1e6))
+	if width == config.Width && height == config.Height {
+		return data, nil
+	}
+	if format := animatedFormat(data); format != "" {
+		return nil, fmt.Errorf("animated %s %w", format, errAnimatedImage)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	resized := resizeImage(img, width, height)
+
+	var buf bytes.Buffer
+	switch ext := sniffExtension(data); ext {
+	case ".jpg":
+		if jpegQuality <= 0 {
+			jpegQuality = defaultConvertQuality
+		}
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: jpegQuality})
+	case ".png":
+		err = png.Encode(&buf, resized)
+	case ".gif":
+		err = gif.Encode(&buf, resized, nil)
+	default:
+		return nil, fmt.Errorf("can't resize %s images", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import "fmt"
+
+// Output directory name of -merge runs
+const mergedInputName = "merged"
+
+// Read all inputs and combine their links into one deduplicated list for
+// -merge. Sources records which inputs referenced each link. Inputs that
+// fail are reported and left out; it's an error only when all of them fail.
+func mergeInputs(paths []string, eopts ExtractOptions, read func(path string) (extractedURLs, error)) (extractedURLs, error) {
+	merged := extractedURLs{
+		Names:   make(map[string]string),
+		Groups:  make(map[string]string),
+		Sources: make(map[string][]string),
+		Input:   mergedInputName,
+	}
+
+	var lastErr error
+	failed := 0
+	for _, path := range paths {
+		fmt.Printf("\n=== %s ===\n", path)
+		extracted, err := read(path)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			lastErr = err
+			failed++
+			continue
+		}
+
+		for _, imageURL := range extracted.URLs {
+			sources := merged.Sources[imageURL]
+			if len(sources) == 0 || sources[len(sources)-1] != extracted.Input {
+				merged.Sources[imageURL] = append(sources, extracted.Input)
+			}
+			if name, ok := extracted.Names[imageURL]; ok {
+				if _, taken := merged.Names[imageURL]; !taken {
+					merged.Names[imageURL] = name
+				}
+			}
+			if group, ok := extracted.Groups[imageURL]; ok {
+				if _, taken := merged.Groups[imageURL]; !taken {
+					merged.Groups[imageURL] = group
+				}
+			}
+		}
+		merged.URLs = append(merged.URLs, extracted.URLs...)
+		merged.Found += extracted.Found
+	}
+	if failed == len(paths) {
+		return extractedURLs{}, lastErr
+	}
+
+	found := len(merged.URLs)
+	merged.URLs = eopts.dedupe(merged.URLs)
+	merged.Names = uniqueNames(merged.URLs, merged.Names)
+	merged.Groups = groupDirs(merged.URLs, merged.Groups)
+	fmt.Printf("\nMerged %d inputs: %d image links, %d unique\n", len(paths)-failed, found, len(merged.URLs))
+	return merged, nil
+}
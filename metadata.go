@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/gif"
+	"strings"
+	"sync"
+)
+
+// Formats already warned about by stripMetadata
+var stripWarned sync.Map
+
+// Remove EXIF, GPS, XMP, IPTC and comment metadata from an image without
+// changing its pixels. JPEG segments and PNG chunks are dropped directly,
+// GIFs are re-encoded, which keeps their palette and frames. Other formats
+// are returned unchanged with a one-time warning.
+func stripMetadata(data []byte) ([]byte, error) {
+	switch ext := sniffExtension(data); ext {
+	case ".jpg":
+		return stripJPEGMetadata(data)
+	case ".png":
+		return stripPNGMetadata(data)
+	case ".gif":
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, anim); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		format := strings.ToUpper(strings.TrimPrefix(ext, "."))
+		if format == "" {
+			format = "unrecognized"
+		}
+		if _, warned := stripWarned.LoadOrStore(format, true); !warned {
+			warnf("  Warning: metadata can't be stripped from %s images, keeping them unchanged", format)
+		}
+		return data, nil
+	}
+}
+
+var errMalformedImage = errors.New("malformed image")
+
+// Drop the APP1 (EXIF, XMP), APP13 (IPTC), other vendor APPn and comment
+// segments of a JPEG. JFIF (APP0), ICC profiles (APP2) and Adobe color
+// information (APP14) are kept since they affect how the image looks.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformedImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xFF {
+			return nil, fmt.Errorf("%w: expected JPEG marker at byte %d", errMalformedImage, pos)
+		}
+		// Skip fill bytes
+		for pos+1 < len(data) && data[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+1 >= len(data) {
+			return nil, errMalformedImage
+		}
+		marker := data[pos+1]
+
+		// Markers without a length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+		// The entropy-coded data follows the start of scan, keep the rest
+		if marker == 0xDA || marker == 0xD9 {
+			out.Write(data[pos:])
+			break
+		}
+
+		if pos+4 > len(data) {
+			return nil, errMalformedImage
+		}
+		// The length counts its own two bytes
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, fmt.Errorf("%w: invalid segment length at byte %d", errMalformedImage, pos)
+		}
+		segment := data[pos:end]
+		pos = end
+
+		keep := true
+		switch {
+		case marker == 0xFE:
+			keep = false
+		case marker == 0xE2:
+			keep = len(segment) >= 4 && bytes.HasPrefix(segment[4:], []byte("ICC_PROFILE\x00"))
+		case marker >= 0xE1 && marker <= 0xEF:
+			keep = marker == 0xEE
+		}
+		if keep {
+			out.Write(segment)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// PNG chunks holding text, EXIF and modification time metadata
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// Drop the metadata chunks of a PNG, keeping all others unchanged
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signatureLen = 8
+	if len(data) < signatureLen {
+		return nil, errMalformedImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:signatureLen])
+	pos := signatureLen
+	for pos < len(data) {
+		// Length, type, data and CRC
+		if pos+12 > len(data) {
+			return nil, errMalformedImage
+		}
+		end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+		if end > len(data) || end < pos {
+			return nil, errMalformedImage
+		}
+		if !pngMetadataChunks[string(data[pos+4:pos+8])] {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestStripJPEGMetadata(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	eoi := []byte{0xFF, 0xD9}
+	exif := []byte{0xFF, 0xE1, 0x00, 0x08, 'E', 'x', 'i', 'f', 0x00, 0x00}
+	icc := append([]byte{0xFF, 0xE2, 0x00, 0x0E}, "ICC_PROFILE\x00"...)
+	comment := []byte{0xFF, 0xFE, 0x00, 0x04, 'h', 'i'}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte // nil when the image is malformed
+	}{
+		{"nothing to strip", join(soi, eoi), join(soi, eoi)},
+		{"EXIF and comment are dropped", join(soi, exif, comment, eoi), join(soi, eoi)},
+		{"ICC profile is kept", join(soi, icc, exif, eoi), join(soi, icc, eoi)},
+		{"zero-length APP2", []byte{0xFF, 0xD8, 0xFF, 0xE2, 0x00, 0x00, 0xFF, 0xD9}, nil},
+		{"one-byte length", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xD9}, nil},
+		{"segment past the end", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x10, 0xFF, 0xD9}, nil},
+		{"truncated length", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00}, nil},
+		{"missing marker", []byte{0xFF, 0xD8, 0x00, 0xE1, 0x00, 0x04}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripJPEGMetadata(tt.data)
+			if tt.want == nil {
+				if !errors.Is(err, errMalformedImage) {
+					t.Errorf("stripJPEGMetadata(% X) = % X, %v, want errMalformedImage", tt.data, got, err)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, tt.want) {
+				t.Errorf("stripJPEGMetadata(% X) = % X, %v, want % X", tt.data, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Run counters exported in Prometheus text format
+type runMetrics struct {
+	start      time.Time
+	downloaded atomic.Int64
+	failed     atomic.Int64
+	skipped    atomic.Int64
+	bytes      atomic.Int64
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{start: time.Now()}
+}
+
+// Count the outcome of one image
+func (m *runMetrics) record(result Result) {
+	switch {
+	case result.Err == nil:
+		m.downloaded.Add(1)
+		m.bytes.Add(result.Size)
+	case errors.Is(result.Err, errAlreadyExists),
+		errors.Is(result.Err, errTooSmall),
+		errors.Is(result.Err, errNotModified),
+		errors.Is(result.Err, errRobots),
+		errors.Is(result.Err, errCircuitOpen),
+		errors.Is(result.Err, errNotImage):
+		m.skipped.Add(1)
+	default:
+		m.failed.Add(1)
+	}
+}
+
+// Write metrics in the Prometheus text exposition format
+func (m *runMetrics) writeTo(w io.Writer) error {
+	var buf bytes.Buffer
+	write := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, kind)
+		fmt.Fprintf(&buf, "%s %v\n", name, value)
+	}
+
+	write("json_shake_images_downloaded_total", "counter", "Images downloaded and saved.", m.downloaded.Load())
+	write("json_shake_images_failed_total", "counter", "Images that failed to download.", m.failed.Load())
+	write("json_shake_images_skipped_total", "counter", "Images skipped (already existing, too small, not modified, disallowed by robots.txt, host circuit open or not images).", m.skipped.Load())
+	write("json_shake_bytes_downloaded_total", "counter", "Bytes written for downloaded images.", m.bytes.Load())
+	write("json_shake_duration_seconds", "gauge", "Time since the run started.", fmt.Sprintf("%.3f", time.Since(m.start).Seconds()))
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Write metrics to a file
+func (m *runMetrics) writeFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.writeTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Listen address of -metrics-port: only local clients can connect unless
+// -metrics-addr names another interface
+func metricsListenAddr(addr string, port int) string {
+	if addr != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
+
+// Expose metrics at /metrics on the given address while the run is in
+// progress. Fails if the address can't be listened on.
+func (m *runMetrics) serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.writeTo(w)
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving metrics at http://%s/metrics\n", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			warnf("Warning: metrics server stopped: %v", err)
+		}
+	}()
+	return nil
+}
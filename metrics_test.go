@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// An address already in use is reported instead of only warned about later
+func TestRunMetricsServeAddressInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if err := newRunMetrics().serve(ln.Addr().String()); err == nil {
+		t.Errorf("serve(%s) succeeded on an address in use", ln.Addr())
+	}
+	if err := newRunMetrics().serve("127.0.0.1:0"); err != nil {
+		t.Errorf("serve(127.0.0.1:0) = %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Turn a JSON field value into a safe filename stem: see sanitizeFilename,
+// and leading spaces and dots are removed too
+func sanitizeNameValue(value string) string {
+	return sanitizeFilename(strings.TrimLeft(value, " ."))
+}
+
+// Filename for an image named after a JSON field, keeping the image
+// extension of the URL path. Returns whether the extension is still missing
+// and has to come from the Content-Type.
+func dataFilename(name string, parsedURL *url.URL) (string, bool) {
+	ext := strings.ToLower(path.Ext(parsedURL.Path))
+	if isImageExtension(ext) {
+		return fitFilename(name + ext), false
+	}
+	return fitFilename(name), true
+}
+
+// Move names or groups to rewritten URLs. before and after hold the same
+// links before and after a rewrite that keeps their positions.
+func carryNames(names map[string]string, before, after []string) {
+	if names == nil || len(before) != len(after) {
+		return
+	}
+	for i, u := range after {
+		if name, ok := names[before[i]]; ok && u != before[i] {
+			if _, taken := names[u]; !taken {
+				names[u] = name
+			}
+		}
+	}
+}
+
+// Keep only the names of the given URLs and make them unique, adding _2, _3
+// etc. to repeated names in URL order, skipping suffixes another name already
+// has. Names are compared case-insensitively, since the macOS and Windows file
+// systems are.
+func uniqueNames(urls []string, names map[string]string) map[string]string {
+	if names == nil {
+		return nil
+	}
+	unique := make(map[string]string)
+	taken := make(map[string]bool)
+	// Next suffix to try for each repeated name
+	next := make(map[string]int)
+	for _, u := range urls {
+		name := sanitizeNameValue(names[u])
+		if name == "" {
+			continue
+		}
+		key := strings.ToLower(name)
+		if taken[key] {
+			n := max(next[key], 2)
+			for taken[strings.ToLower(fmt.Sprintf("%s_%d", name, n))] {
+				n++
+			}
+			next[key] = n + 1
+			name = fmt.Sprintf("%s_%d", name, n)
+		}
+		taken[strings.ToLower(name)] = true
+		unique[u] = name
+	}
+	return unique
+}
+
+// Keep only the groups of the given URLs, as directory names made safe like
+// names. Unlike names, groups are shared by many images.
+func groupDirs(urls []string, groups map[string]string) map[string]string {
+	if groups == nil {
+		return nil
+	}
+	dirs := make(map[string]string)
+	for _, u := range urls {
+		if dir := sanitizeNameValue(groups[u]); dir != "" {
+			dirs[u] = dir
+		}
+	}
+	return dirs
+}
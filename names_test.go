@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFitFilename(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		name     string
+		filename string
+		ext      string
+	}{
+		{"short names are kept", "photo.jpg", ".jpg"},
+		{"at the limit", strings.Repeat("a", maxFilenameBytes-4) + ".jpg", ".jpg"},
+		{"over the limit", long + ".jpg", ".jpg"},
+		{"without extension", long, ""},
+		{"long extension is part of the stem", "photo." + long, ""},
+		{"two-byte characters", strings.Repeat("é", 200) + ".png", ".png"},
+		{"three-byte characters", "x" + strings.Repeat("画", 100) + ".png", ".png"},
+		{"four-byte characters", "xy" + strings.Repeat("😀", 80) + ".gif", ".gif"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fitFilename(tt.filename)
+			if len(got) > maxFilenameBytes {
+				t.Errorf("fitFilename gave %d bytes, want at most %d", len(got), maxFilenameBytes)
+			}
+			if len(tt.filename) <= maxFilenameBytes && got != tt.filename {
+				t.Errorf("fitFilename(%q) = %q, want it unchanged", tt.filename, got)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("fitFilename cut a character in half: %q", got)
+			}
+			if filepath.Ext(got) != tt.ext && tt.ext != "" {
+				t.Errorf("extension of %q = %q, want %q", got, filepath.Ext(got), tt.ext)
+			}
+		})
+	}
+}
+
+// Long names that differ only after the cut stay different
+func TestFitFilenameUnique(t *testing.T) {
+	prefix := strings.Repeat("b", 300)
+	seen := make(map[string]string)
+	for _, filename := range []string{prefix + "1.jpg", prefix + "2.jpg", prefix + ".jpg", prefix + "1.png"} {
+		got := fitFilename(filename)
+		if other, ok := seen[got]; ok {
+			t.Errorf("%q and %q both fit to %q", other, filename, got)
+		}
+		seen[got] = filename
+	}
+}
+
+// The default filename of a very long URL fits the limit
+func TestDefaultFilenameLongURL(t *testing.T) {
+	parsedURL, err := url.Parse("https://cdn.example.com/images/" + strings.Repeat("very-long-segment-", 50) + "photo.jpg?w=800")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := defaultFilename(parsedURL, "", 1, 0)
+	if len(got) > maxFilenameBytes || !strings.HasSuffix(got, ".jpg") {
+		t.Errorf("defaultFilename = %q (%d bytes), want at most %d bytes ending in .jpg", got, len(got), maxFilenameBytes)
+	}
+}
+
+func TestUniqueNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{"distinct names", []string{"a", "b"}, []string{"a", "b"}},
+		{"repeated names", []string{"photo", "photo", "photo"}, []string{"photo", "photo_2", "photo_3"}},
+		{"case-insensitive", []string{"Photo", "photo", "PHOTO"}, []string{"Photo", "photo_2", "PHOTO_3"}},
+		{"suffix already taken", []string{"photo", "photo_2", "photo"}, []string{"photo", "photo_2", "photo_3"}},
+		{"suffix taken later", []string{"photo", "photo", "photo_2"}, []string{"photo", "photo_2", "photo_2_2"}},
+		{"several suffixes taken", []string{"x_2", "x_3", "x", "x", "x"}, []string{"x_2", "x_3", "x", "x_4", "x_5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var urls []string
+			names := make(map[string]string)
+			for i, name := range tt.names {
+				u := fmt.Sprintf("https://example.com/%d.jpg", i)
+				urls = append(urls, u)
+				names[u] = name
+			}
+			unique := uniqueNames(urls, names)
+			for i, u := range urls {
+				if unique[u] != tt.want[i] {
+					t.Errorf("names = %v, want %v", unique, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Check whether an input argument is an API URL instead of a file
+func isURLInput(input string) bool {
+	return strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://")
+}
+
+// Output directory name for an API URL: the last path segment without its
+// extension, or the host for URLs without a path
+func urlInputName(apiURL string) string {
+	parsedURL, err := url.Parse(apiURL)
+	if err != nil {
+		return "api"
+	}
+	name := path.Base(parsedURL.Path)
+	if name == "" || name == "." || name == "/" {
+		return parsedURL.Host
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// Next page cursor of a response: a top-level field, or the value at a JSON
+// Pointer such as /data/items/pageInfo/endCursor. Empty when there is none.
+func cursorValue(doc interface{}, field string) string {
+	var value interface{}
+	if strings.HasPrefix(field, "/") {
+		v, err := resolveJSONPointer(doc, field)
+		if err != nil {
+			return ""
+		}
+		value = v
+	} else if obj, ok := doc.(map[string]interface{}); ok {
+		value = obj[field]
+	}
+
+	switch value.(type) {
+	case string, float64:
+		return jsonScalarString(value)
+	}
+	return ""
+}
+
+// Fetch the pages of an API URL. Without a cursor field only the URL itself
+// is fetched. Otherwise the cursor of each page is sent as the cursor
+// parameter of the next request, until a page has no cursor, a cursor
+// repeats or maxPages pages have been fetched.
+func fetchPages(client Doer, apiURL string, eopts ExtractOptions) ([]interface{}, error) {
+	parsedURL, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+
+	var pages []interface{}
+	seen := make(map[string]bool)
+	pageURL := apiURL
+	for {
+		if eopts.CursorField != "" {
+			fmt.Fprintf(statusOut, "Fetching page %d: %s\n", len(pages)+1, pageURL)
+		}
+		doc, err := fetchJSON(client, pageURL)
+		if err != nil {
+			if len(pages) == 0 {
+				return nil, err
+			}
+			// Keep the pages fetched so far
+			warnf("Warning: failed to fetch page %d: %v", len(pages)+1, err)
+			return pages, nil
+		}
+		pages = append(pages, doc)
+
+		if eopts.CursorField == "" {
+			return pages, nil
+		}
+		cursor := cursorValue(doc, eopts.CursorField)
+		if cursor == "" {
+			return pages, nil
+		}
+		if seen[cursor] {
+			warnf("Warning: cursor %q repeated, stopping pagination", cursor)
+			return pages, nil
+		}
+		seen[cursor] = true
+		if len(pages) >= eopts.MaxPages {
+			fmt.Fprintf(statusOut, "Reached the limit of %d pages, not fetching more\n", eopts.MaxPages)
+			return pages, nil
+		}
+
+		query := parsedURL.Query()
+		query.Set(eopts.CursorParam, cursor)
+		next := *parsedURL
+		next.RawQuery = query.Encode()
+		pageURL = next.String()
+	}
+}
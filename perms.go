@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Permissions of created output directories and files. Zero values keep the
+// defaults of 0755 and 0644, reduced by the umask. Explicit modes are applied
+// with chmod so the umask doesn't change them.
+type fileModes struct {
+	dir  os.FileMode
+	file os.FileMode
+}
+
+// Parse an octal permission such as "0700"
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q (expected octal permissions like 0700)", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Create a directory and its missing parents
+func (m fileModes) mkdirAll(path string) error {
+	if m.dir == 0 {
+		return os.MkdirAll(path, 0755)
+	}
+
+	// Only directories created here get the mode
+	var created []string
+	for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+		if _, err := os.Stat(p); err == nil {
+			break
+		}
+		created = append(created, p)
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(path, m.dir); err != nil {
+		return err
+	}
+	for _, dir := range created {
+		if err := os.Chmod(dir, m.dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write a file, creating or replacing it
+func (m fileModes) writeFile(path string, data []byte) error {
+	if m.file == 0 {
+		return os.WriteFile(path, data, 0644)
+	}
+	if err := os.WriteFile(path, data, m.file); err != nil {
+		return err
+	}
+	return os.Chmod(path, m.file)
+}
+
+// Open a file for writing with os.OpenFile flags
+func (m fileModes) openFile(path string, flags int) (*os.File, error) {
+	if m.file == 0 {
+		return os.OpenFile(path, flags, 0644)
+	}
+	file, err := os.OpenFile(path, flags, m.file)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(m.file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
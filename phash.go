@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Difference hash of an image: the image is shrunk to 9x8 gray pixels and
+// each bit tells whether a pixel is brighter than its right neighbour.
+// Rescaled or recompressed copies of a picture get hashes that differ in
+// only a few bits.
+func differenceHash(img image.Image) uint64 {
+	small := resizeImage(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if luminance(small, x, y) > luminance(small, x+1, y) {
+				hash |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return hash
+}
+
+func luminance(img *image.RGBA, x, y int) uint32 {
+	c := img.RGBAAt(x, y)
+	return 299*uint32(c.R) + 587*uint32(c.G) + 114*uint32(c.B)
+}
+
+// Collapse groups of near-duplicate images. Each group is led by the image
+// with the highest resolution (the larger file on ties) that isn't in a group
+// yet, and holds the images whose difference hashes are at most threshold
+// bits from the leader's, so images are never grouped only through another.
+// The leader is kept and the others are deleted along with their sidecar,
+// headers, original and variant files, except files that were there before
+// this run. results holds the download result of each path. Returns the paths
+// without the deleted files, and the results of the deleted files marked as
+// near duplicates.
+func dedupePerceptual(paths []string, results map[string]Result, threshold int) ([]string, []Result) {
+	type hashedImage struct {
+		path   string
+		hash   uint64
+		pixels int
+		size   int64
+	}
+
+	// Hash the decodable images, ignoring duplicates and missing files
+	var images []hashedImage
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		// Flat images all hash to 0, so hashes within the threshold of it
+		// don't tell pictures apart
+		hash := differenceHash(img)
+		if ones := bits.OnesCount64(hash); ones <= threshold || 64-ones <= threshold {
+			continue
+		}
+		bounds := img.Bounds()
+		images = append(images, hashedImage{path: path, hash: hash, pixels: bounds.Dx() * bounds.Dy(), size: info.Size()})
+	}
+
+	// Highest resolution first, then largest file
+	sort.SliceStable(images, func(i, j int) bool {
+		if images[i].pixels != images[j].pixels {
+			return images[i].pixels > images[j].pixels
+		}
+		return images[i].size > images[j].size
+	})
+
+	removed := make(map[string]bool)
+	var removedResults []Result
+	grouped := make([]bool, len(images))
+	groupCount := 0
+	for i, keep := range images {
+		if grouped[i] {
+			continue
+		}
+		var group []hashedImage
+		for j := i + 1; j < len(images); j++ {
+			if !grouped[j] && bits.OnesCount64(keep.hash^images[j].hash) <= threshold {
+				grouped[j] = true
+				group = append(group, images[j])
+			}
+		}
+		if len(group) == 0 {
+			continue
+		}
+		if groupCount == 0 {
+			fmt.Println("\nNear-duplicate images:")
+		}
+		groupCount++
+
+		fmt.Printf("  Keeping %s\n", filepath.Base(keep.path))
+		for _, img := range group {
+			distance := bits.OnesCount64(keep.hash ^ img.hash)
+			result := results[img.path]
+			if errors.Is(result.Err, errAlreadyExists) {
+				fmt.Printf("    Kept %s (distance %d), it existed before this run\n", filepath.Base(img.path), distance)
+				continue
+			}
+			if err := os.Remove(img.path); err != nil {
+				warnf("    Warning: failed to remove %s: %v", filepath.Base(img.path), err)
+				continue
+			}
+			removeCompanions(img.path, result)
+			removed[img.path] = true
+			result.Err = fmt.Errorf("%w of %s", errNearDuplicate, filepath.Base(keep.path))
+			result.OriginalPath = ""
+			result.Variants = nil
+			removedResults = append(removedResults, result)
+			fmt.Printf("    Removed %s (distance %d)\n", filepath.Base(img.path), distance)
+		}
+	}
+	if groupCount == 0 {
+		fmt.Println("\nNo near-duplicate images found")
+		return paths, nil
+	}
+	fmt.Printf("Removed %d near-duplicate images in %d groups\n", len(removed), groupCount)
+
+	var kept []string
+	for _, path := range paths {
+		if !removed[path] {
+			kept = append(kept, path)
+		}
+	}
+	return kept, removedResults
+}
+
+// Delete the files written next to a saved image, ignoring those that were
+// never written
+func removeCompanions(imagePath string, result Result) {
+	companions := []string{imagePath + sidecarExt, imagePath + headersExt}
+	if result.OriginalPath != "" {
+		companions = append(companions, result.OriginalPath)
+	}
+	for _, variantPath := range result.Variants {
+		companions = append(companions, variantPath)
+	}
+	for _, p := range companions {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			warnf("    Warning: failed to remove %s: %v", filepath.Base(p), err)
+		}
+	}
+}
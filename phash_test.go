@@ -0,0 +1,195 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Image of 9x8 gray blocks of scale pixels each, whose difference hash is
+// hash
+func imageWithHash(hash uint64, scale int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 9*scale, 8*scale))
+	for y := 0; y < 8; y++ {
+		level := 128
+		for x := 0; x < 9; x++ {
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetGray(x*scale+px, y*scale+py, color.Gray{uint8(level)})
+				}
+			}
+			if x < 8 && hash&(1<<(y*8+x)) != 0 {
+				level -= 10
+			} else {
+				level += 10
+			}
+		}
+	}
+	return img
+}
+
+// Image of a single gray level, whose difference hash is 0
+func flatImage(level uint8, width int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, width))
+	for i := range img.Pix {
+		img.Pix[i] = level
+	}
+	return img
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDedupePerceptual(t *testing.T) {
+	const base = 0x5a5a5a5a5a5a5a5a
+	const nearBase = base ^ 0xf           // 4 bits from base
+	const nearNear = nearBase ^ 0xf0      // 4 bits from nearBase, 8 from base
+	const otherPicture = base ^ 0xffff000 // 16 bits from base
+
+	tests := []struct {
+		name     string
+		images   map[string]image.Image
+		existing []string
+		want     []string // Files left
+	}{
+		{
+			name: "near duplicates of the largest image",
+			images: map[string]image.Image{
+				"large.png":  imageWithHash(base, 20),
+				"small.png":  imageWithHash(nearBase, 10),
+				"other.png":  imageWithHash(otherPicture, 10),
+				"medium.png": imageWithHash(base, 15),
+			},
+			want: []string{"large.png", "other.png"},
+		},
+		{
+			name: "not grouped through another image",
+			images: map[string]image.Image{
+				"a.png": imageWithHash(base, 20),
+				"b.png": imageWithHash(nearBase, 10),
+				"c.png": imageWithHash(nearNear, 5),
+			},
+			want: []string{"a.png", "c.png"},
+		},
+		{
+			name: "flat images are not compared",
+			images: map[string]image.Image{
+				"white.png": flatImage(255, 90),
+				"black.png": flatImage(0, 45),
+			},
+			want: []string{"black.png", "white.png"},
+		},
+		{
+			name: "files from before the run are kept",
+			images: map[string]image.Image{
+				"new.png": imageWithHash(base, 20),
+				"old.png": imageWithHash(nearBase, 10),
+			},
+			existing: []string{"old.png"},
+			want:     []string{"new.png", "old.png"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var paths []string
+			for name, img := range tt.images {
+				path := filepath.Join(dir, name)
+				writePNG(t, path, img)
+				paths = append(paths, path)
+			}
+			results := make(map[string]Result)
+			for _, name := range tt.existing {
+				results[filepath.Join(dir, name)] = Result{Err: errAlreadyExists}
+			}
+
+			kept, _ := dedupePerceptual(paths, results, 5)
+
+			names := dirNames(t, dir)
+			if len(names) != len(tt.want) || len(kept) != len(tt.want) {
+				t.Fatalf("files left = %v, want %v (returned %d paths)", names, tt.want, len(kept))
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("files left = %v, want %v", names, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+// Deleted near duplicates take their sidecar, headers, original and variant
+// files with them, and are recorded as skipped in the manifest and summary
+func TestDedupePerceptualCompanions(t *testing.T) {
+	const hash = 0x5a5a5a5a5a5a5a5a
+	dir := t.TempDir()
+	results := make(map[string]Result)
+	var paths []string
+	for _, image := range []struct {
+		name  string
+		scale int
+	}{{"large.png", 20}, {"small.png", 10}} {
+		p := filepath.Join(dir, image.name)
+		writePNG(t, p, imageWithHash(hash, image.scale))
+		result := Result{
+			URL:          "https://example.com/" + image.name,
+			Path:         p,
+			Size:         100,
+			OriginalPath: filepath.Join(dir, originalsDir, image.name),
+			Variants:     map[string]string{variantThumbnail: filepath.Join(dir, variantThumbnail, image.name)},
+		}
+		for _, companion := range []string{p + sidecarExt, p + headersExt, result.OriginalPath, result.Variants[variantThumbnail]} {
+			writeTestFile(t, companion, []byte("data"))
+		}
+		results[p] = result
+		paths = append(paths, p)
+	}
+	summary := newRunSummary()
+	for _, p := range paths {
+		summary.record(results[p])
+	}
+
+	kept, removed := dedupePerceptual(paths, results, 5)
+	for _, result := range removed {
+		summary.reclassify(results[result.Path], result)
+	}
+
+	if len(kept) != 1 || filepath.Base(kept[0]) != "large.png" {
+		t.Fatalf("kept = %v, want only large.png", kept)
+	}
+	for _, sub := range []string{".", originalsDir, variantThumbnail} {
+		for _, name := range dirNames(t, filepath.Join(dir, sub)) {
+			if strings.HasPrefix(name, "small.png") {
+				t.Errorf("%s was not removed", filepath.Join(sub, name))
+			}
+		}
+	}
+	if got := dirNames(t, dir); len(got) != 5 {
+		t.Errorf("files left = %v, want large.png with its sidecar and headers", got)
+	}
+
+	if len(removed) != 1 || resultStatus(removed[0]) != "near_duplicate" || removed[0].SkipReason() != SkipNearDuplicate {
+		t.Fatalf("removed = %+v, want small.png as a near duplicate", removed)
+	}
+	if removed[0].Err.Error() != "near duplicate of large.png" {
+		t.Errorf("error = %q, want near duplicate of large.png", removed[0].Err)
+	}
+	if summary.Total != 2 || summary.Statuses["downloaded"] != 1 || summary.Statuses["near_duplicate"] != 1 ||
+		summary.Skipped[SkipNearDuplicate] != 1 || summary.Bytes != 100 {
+		t.Errorf("summary = %+v, want one downloaded and one near duplicate image", summary.summaryCounts)
+	}
+}
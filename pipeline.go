@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Images queued between two pipeline stages when PipelineBuffer isn't set
+const defaultPipelineBuffer = 8
+
+// An image moving through the download, compress and write stages. Once
+// result.Err is set, later stages pass it on untouched.
+type pipelineJob struct {
+	index int // 1-based position in the URL list
+	host  string
+	img   *pendingImage
+
+	result Result
+}
+
+// Download the images with separate worker pools for downloading,
+// processing and writing, so network, CPU and disk work overlap. The stages
+// are connected by bounded channels: a slow stage blocks the one before it,
+// which keeps at most a few images per worker in memory. Progress is
+// reported in completion order; the results are returned in URL order.
+func downloadPipeline(client Doer, imageURLs []string, sink Sink, opts Options, breaker *hostBreaker, robots *robotsCache) []Result {
+	total := len(imageURLs)
+	buffer := opts.PipelineBuffer
+	if buffer <= 0 {
+		buffer = defaultPipelineBuffer
+	}
+
+	queued := make(chan *pipelineJob)
+	fetched := make(chan *pipelineJob, buffer)
+	processed := make(chan *pipelineJob, buffer)
+	finished := make(chan *pipelineJob, buffer)
+
+	go func() {
+		for i, imageURL := range imageURLs {
+			queued <- &pipelineJob{index: i + 1, result: Result{URL: imageURL, Index: i + 1, Shard: opts.shardFor(i + 1)}}
+		}
+		close(queued)
+	}()
+
+	// Download stage
+	var imageMu sync.Mutex // Keeps OnImage calls from overlapping
+	runStage(opts.DownloadWorkers, queued, fetched, func(job *pipelineJob) {
+		imageURL := job.result.URL
+		if opts.OnImage != nil {
+			imageMu.Lock()
+			opts.OnImage(job.index, total, imageURL)
+			imageMu.Unlock()
+		}
+		if parsedURL, err := url.Parse(imageURL); err == nil {
+			job.host = parsedURL.Host
+		}
+		if job.result.Err = precheckImage(imageURL, job.host, breaker, robots); job.result.Err != nil {
+			return
+		}
+		var existing savedImage
+		job.img, existing, job.result.Err = fetchImage(client, imageURL, sink, job.index, opts)
+		job.result.Path, job.result.Size = existing.Path, existing.Size
+		// Record the outcome right away, so the next downloads from the
+		// host see it without waiting for the later stages
+		breaker.record(job.host, isHostFailure(job.result.Err))
+	})
+
+	// Compress stage
+	runStage(opts.CompressWorkers, fetched, processed, func(job *pipelineJob) {
+		if job.result.Err == nil {
+			processImage(job.img, opts)
+		}
+	})
+
+	// Write stage. Images fetched at the same time may share a name, so the
+	// check for an existing file is repeated under a lock on the path.
+	var pathLocks sync.Map // output path -> *sync.Mutex
+	runStage(opts.WriteWorkers, processed, finished, func(job *pipelineJob) {
+		if job.result.Err != nil {
+			return
+		}
+		lock, _ := pathLocks.LoadOrStore(job.img.outputPath, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+
+		if !opts.RenameExisting {
+			if size, exists, err := sink.Stat(job.img.outputPath); err == nil && exists {
+				if job.img.partPath != "" {
+					removePartFiles(job.img.partPath)
+				}
+				job.result.Path, job.result.Size, job.result.Err = sink.Location(job.img.outputPath), size, errAlreadyExists
+				return
+			}
+		}
+		saved, err := writeImage(job.img, sink, opts)
+		job.result.Path, job.result.Size, job.result.OriginalPath, job.result.Err = saved.Path, saved.Size, saved.OriginalPath, err
+		job.result.Variants = saved.Variants
+		job.img = nil // Release the image data
+	})
+
+	results := make([]Result, 0, total)
+	for job := range finished {
+		results = append(results, job.result)
+		if opts.OnProgress != nil {
+			opts.OnProgress(len(results), total, job.result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	return results
+}
+
+// Run work on each job from in with the given number of workers and pass
+// the jobs on to out, which is closed once in is drained
+func runStage(workers int, in <-chan *pipelineJob, out chan<- *pipelineJob, work func(job *pipelineJob)) {
+	var wg sync.WaitGroup
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range in {
+				work(job)
+				out <- job
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Two URLs saved under the same name with -resume must not share a .part
+// file: one image is saved whole and the other is skipped as existing.
+func TestDownloadPipelineResumeSharedName(t *testing.T) {
+	bodies := map[string][]byte{
+		"/a/photo.jpg": bytes.Repeat([]byte{'a'}, 9000),
+		"/b/photo.jpg": bytes.Repeat([]byte{'b'}, 11000),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+r.URL.Path+`"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(bodies[r.URL.Path])))
+		w.Write(bodies[r.URL.Path])
+	}))
+	defer server.Close()
+
+	// Pause both bodies halfway until the other got there too, so the
+	// downloads are written at the same time
+	var halfway sync.WaitGroup
+	halfway.Add(len(bodies))
+	client := doerFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := server.Client().Do(req)
+		if err == nil {
+			resp.Body = &pausingBody{ReadCloser: resp.Body, at: resp.ContentLength / 2, pause: &halfway}
+		}
+		return resp, err
+	})
+
+	dir := t.TempDir()
+	opts := Options{Resume: true, DownloadWorkers: 2, CompressWorkers: 1, WriteWorkers: 2}
+	results := downloadAll(client, []string{server.URL + "/a/photo.jpg", server.URL + "/b/photo.jpg"}, newDirSink(dir, fileModes{}), opts)
+
+	saved := 0
+	for _, result := range results {
+		switch {
+		case result.Err == nil:
+			saved++
+		case !errors.Is(result.Err, errAlreadyExists):
+			t.Errorf("%s: %v", result.URL, result.Err)
+		}
+	}
+	if saved != 1 {
+		t.Errorf("saved %d images, want 1", saved)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "photo.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bodies["/a/photo.jpg"]) && !bytes.Equal(data, bodies["/b/photo.jpg"]) {
+		t.Errorf("photo.jpg holds %d bytes mixed from both responses", len(data))
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".part") {
+			t.Errorf("partial file %s left behind", entry.Name())
+		}
+	}
+}
+
+// Compare the pipeline with downloading one image at a time, from a server
+// answering after a short delay like a remote host would
+func BenchmarkDownloadAll(b *testing.B) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	imageURLs := make([]string, 32)
+	for i := range imageURLs {
+		imageURLs[i] = fmt.Sprintf("%s/%d.png", server.URL, i)
+	}
+
+	for _, bench := range []struct {
+		name string
+		opts Options
+	}{
+		{"sequential", Options{}},
+		{"pipeline", Options{DownloadWorkers: 4, CompressWorkers: 2, WriteWorkers: 2}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sink := newDirSink(b.TempDir(), fileModes{})
+				for _, result := range downloadAll(server.Client(), imageURLs, sink, bench.opts) {
+					if result.Err != nil {
+						b.Fatal(result.Err)
+					}
+				}
+			}
+			b.ReportMetric(float64(b.N*len(imageURLs))/b.Elapsed().Seconds(), "images/s")
+		})
+	}
+}
+
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Response body that stops at byte at until all bodies sharing pause got
+// there
+type pausingBody struct {
+	io.ReadCloser
+	at    int64
+	n     int64
+	pause *sync.WaitGroup
+}
+
+func (b *pausingBody) Read(p []byte) (int, error) {
+	if b.n < b.at && b.n+int64(len(p)) > b.at {
+		p = p[:b.at-b.n]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if b.n < b.at && b.n+int64(n) >= b.at {
+		b.pause.Done()
+		b.pause.Wait()
+	}
+	b.n += int64(n)
+	return n, err
+}
+
+// The circuit breaker opens as soon as the failures are downloaded, not once
+// they reach the end of the pipeline, so a slow consumer of the progress
+// doesn't let more requests reach a failing host
+func TestDownloadPipelineBreaker(t *testing.T) {
+	var requests int
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var imageURLs []string
+	for i := 0; i < 12; i++ {
+		imageURLs = append(imageURLs, fmt.Sprintf("%s/%d.jpg", server.URL, i))
+	}
+	opts := Options{
+		HostFailureThreshold: 3,
+		DownloadWorkers:      1,
+		CompressWorkers:      1,
+		WriteWorkers:         1,
+		OnProgress:           func(int, int, Result) { time.Sleep(10 * time.Millisecond) },
+	}
+	results := downloadAll(server.Client(), imageURLs, newDirSink(t.TempDir(), fileModes{}), opts)
+
+	if requests != opts.HostFailureThreshold {
+		t.Errorf("got %d requests, want %d before the circuit opened", requests, opts.HostFailureThreshold)
+	}
+	for _, result := range results[opts.HostFailureThreshold:] {
+		if !errors.Is(result.Err, errCircuitOpen) {
+			t.Errorf("%s: %v, want the circuit open", result.URL, result.Err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Resolve an RFC 6901 JSON Pointer such as "/products/0/images" against
+// decoded JSON. The empty pointer selects the whole document.
+func resolveJSONPointer(data interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return data, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q (must start with /)", pointer)
+	}
+
+	node := data
+	resolved := ""
+	for _, token := range strings.Split(pointer[1:], "/") {
+		// Unescape ~1 to / before ~0 to ~
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+
+		switch value := node.(type) {
+		case map[string]interface{}:
+			child, ok := value[token]
+			if !ok {
+				return nil, fmt.Errorf("JSON pointer %q: key %q not found at %q", pointer, token, resolvedOrRoot(resolved))
+			}
+			node = child
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+				return nil, fmt.Errorf("JSON pointer %q: invalid array index %q at %q", pointer, token, resolvedOrRoot(resolved))
+			}
+			if index >= len(value) {
+				return nil, fmt.Errorf("JSON pointer %q: index %d out of range at %q (length %d)", pointer, index, resolvedOrRoot(resolved), len(value))
+			}
+			node = value[index]
+		default:
+			return nil, fmt.Errorf("JSON pointer %q: %q is not an object or array", pointer, resolvedOrRoot(resolved))
+		}
+		resolved += "/" + strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+	}
+	return node, nil
+}
+
+func resolvedOrRoot(pointer string) string {
+	if pointer == "" {
+		return "/"
+	}
+	return pointer
+}
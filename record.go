@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers whose values are replaced in the request log
+var secretHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// Placeholder for secret header values in the request log
+const redactedValue = "REDACTED"
+
+// One line of the -record request log
+type recordedRequest struct {
+	Time       time.Time           `json:"time"`
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Headers    map[string][]string `json:"headers,omitempty"` // Secret values are REDACTED
+	Status     int                 `json:"status,omitempty"`
+	Bytes      int64               `json:"bytes"` // Response body bytes read
+	DurationMS int64               `json:"duration_ms"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// RoundTripper appending a JSON line per request to a log, including
+// redirects, retries and mirror attempts. Lines are written once the
+// response body is closed, so they carry the bytes actually read.
+type recordingTransport struct {
+	base http.RoundTripper
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newRecordingTransport(base http.RoundTripper, path string) (*recordingTransport, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingTransport{base: base, file: file}, nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := recordedRequest{
+		Time:    time.Now().UTC(),
+		Method:  req.Method,
+		URL:     req.URL.Redacted(),
+		Headers: redactHeaders(req.Header),
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		entry.Error = err.Error()
+		entry.DurationMS = time.Since(start).Milliseconds()
+		t.write(entry)
+		return nil, err
+	}
+
+	entry.Status = resp.StatusCode
+	resp.Body = &recordedBody{ReadCloser: resp.Body, done: func(n int64) {
+		entry.Bytes = n
+		entry.DurationMS = time.Since(start).Milliseconds()
+		t.write(entry)
+	}}
+	return resp, nil
+}
+
+func (t *recordingTransport) write(entry recordedRequest) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.file.Write(append(data, '\n'))
+}
+
+func (t *recordingTransport) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file.Close()
+}
+
+// Response body counting the bytes read, reporting them once on Close
+type recordedBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64)
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
+
+// Copy headers with the values of secret ones replaced
+func redactHeaders(header http.Header) map[string][]string {
+	if len(header) == 0 {
+		return nil
+	}
+	redacted := make(map[string][]string, len(header))
+	for key, values := range header {
+		if secretHeaders[http.CanonicalHeaderKey(key)] {
+			values = []string{redactedValue}
+		}
+		redacted[key] = values
+	}
+	return redacted
+}
+
+// Send the requests of a -record log again and compare each status and body
+// size with the recorded ones. Redacted headers aren't sent; -auth-command
+// and -host-auth supply fresh credentials through client. Returns the number
+// of requests that differed.
+func replayRequests(client Doer, path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var entries []recordedRequest
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry recordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return 0, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("Replaying %d requests from %s\n", len(entries), path)
+	differed := 0
+	for i, entry := range entries {
+		got := replayRequest(client, entry)
+		same := got.Status == entry.Status && got.Bytes == entry.Bytes && (got.Error == "") == (entry.Error == "")
+		line := fmt.Sprintf("[%d/%d] %s %s -> %s", i+1, len(entries), entry.Method, entry.URL, describeRecorded(got))
+		if same {
+			fmt.Println(colorize(colorGreen, line))
+		} else {
+			differed++
+			fmt.Println(colorize(colorRed, fmt.Sprintf("%s (recorded: %s)", line, describeRecorded(entry))))
+		}
+	}
+
+	fmt.Printf("\nReplay complete! Matched: %d, Differed: %d, Total: %d\n", len(entries)-differed, differed, len(entries))
+	return differed, nil
+}
+
+// Send one recorded request, reading and discarding the body
+func replayRequest(client Doer, entry recordedRequest) recordedRequest {
+	req, err := http.NewRequest(entry.Method, entry.URL, nil)
+	if err != nil {
+		return recordedRequest{Error: err.Error()}
+	}
+	for key, values := range entry.Headers {
+		if secretHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		req.Header[key] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return recordedRequest{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	got := recordedRequest{Status: resp.StatusCode, Bytes: n}
+	if err != nil {
+		got.Error = err.Error()
+	}
+	return got
+}
+
+// Status and size of a request, e.g. "200, 5120 bytes"
+func describeRecorded(r recordedRequest) string {
+	if r.Error != "" {
+		return "error: " + r.Error
+	}
+	return fmt.Sprintf("%d, %d bytes", r.Status, r.Bytes)
+}
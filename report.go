@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Counts of download outcomes
+type runStats struct {
+	success     int
+	failed      int
+	exists      int // Counted as success, but skipped as already saved
+	tooSmall    int
+	notModified int
+	robots      int // Skipped because robots.txt disallows them
+	circuitOpen int
+	corrupt     int // Failures rejected by -strict-validate as corrupt images
+	notImage    int // Skipped by -strict-validate as non-images
+	truncated   int // Failures rejected by -verify-length
+	total       int
+}
+
+func (s *runStats) add(other runStats) {
+	s.success += other.success
+	s.failed += other.failed
+	s.exists += other.exists
+	s.tooSmall += other.tooSmall
+	s.notModified += other.notModified
+	s.robots += other.robots
+	s.circuitOpen += other.circuitOpen
+	s.corrupt += other.corrupt
+	s.notImage += other.notImage
+	s.truncated += other.truncated
+	s.total += other.total
+}
+
+// Print the statistics lines of a run
+func (s runStats) print() {
+	fmt.Printf("Success: %d, Failed: %d, Total: %d\n", s.success, s.failed, s.total)
+	if s.exists > 0 {
+		fmt.Printf("Skipped (already exists): %d\n", s.exists)
+	}
+	if s.tooSmall > 0 {
+		fmt.Printf("Skipped (too small): %d\n", s.tooSmall)
+	}
+	if s.notModified > 0 {
+		fmt.Printf("Skipped (not modified since -since): %d\n", s.notModified)
+	}
+	if s.robots > 0 {
+		fmt.Printf("Skipped (disallowed by robots.txt): %d\n", s.robots)
+	}
+	if s.circuitOpen > 0 {
+		fmt.Printf("Skipped (host circuit open): %d\n", s.circuitOpen)
+	}
+	if s.notImage > 0 {
+		fmt.Printf("Skipped (not an image): %d\n", s.notImage)
+	}
+	if s.corrupt > 0 {
+		fmt.Printf("Rejected (corrupt image): %d\n", s.corrupt)
+	}
+	if s.truncated > 0 {
+		fmt.Printf("Failed (length mismatch): %d\n", s.truncated)
+	}
+}
+
+// Console output of the CLI, implemented on the Options progress callbacks
+type consoleReporter struct {
+	minBytes   int64
+	metrics    *runMetrics
+	summary    *runSummary       // Set with -summary
+	stats      runStats          // Counts for the current input
+	savedPaths []string          // Files saved or already present for the current input
+	results    map[string]Result // Results of savedPaths, by path
+}
+
+// Reset counters before processing the next input
+func (c *consoleReporter) reset() {
+	c.stats = runStats{}
+	c.savedPaths = nil
+	c.results = make(map[string]Result)
+}
+
+func (c *consoleReporter) onStart(total int) {
+	c.stats.total = total
+}
+
+func (c *consoleReporter) onImage(index, total int, imageURL string) {
+	fmt.Printf("[%d/%d] Downloading: %s\n", index, total, imageURL)
+}
+
+func (c *consoleReporter) onProgress(done, total int, result Result) {
+	c.metrics.record(result)
+	c.summary.record(result)
+	switch {
+	case errors.Is(result.Err, errAlreadyExists):
+		fmt.Println(colorize(colorYellow, fmt.Sprintf("File already exists, skipping: %s", filepath.Base(result.Path))))
+		c.stats.success++
+		c.stats.exists++
+		c.savedPaths = append(c.savedPaths, result.Path)
+		c.results[result.Path] = result
+	case errors.Is(result.Err, errTooSmall):
+		fmt.Println(colorize(colorYellow, fmt.Sprintf("- Skipped: smaller than %d bytes", c.minBytes)))
+		c.stats.tooSmall++
+	case errors.Is(result.Err, errNotModified):
+		fmt.Println(colorize(colorYellow, fmt.Sprintf("- Skipped: %v", result.Err)))
+		c.stats.notModified++
+	case errors.Is(result.Err, errRobots):
+		fmt.Println(colorize(colorYellow, fmt.Sprintf("- Skipped: %v", result.Err)))
+		c.stats.robots++
+	case errors.Is(result.Err, errCircuitOpen):
+		fmt.Println(colorize(colorYellow, fmt.Sprintf("- Skipped: %v", result.Err)))
+		c.stats.circuitOpen++
+	case errors.Is(result.Err, errNotImage):
+		fmt.Println(colorize(colorYellow, fmt.Sprintf("- Skipped: %v", result.Err)))
+		c.stats.notImage++
+	case errors.Is(result.Err, errCorruptImage):
+		fmt.Println(colorize(colorRed, fmt.Sprintf("✗ Rejected: %v", result.Err)))
+		c.stats.failed++
+		c.stats.corrupt++
+	case errors.Is(result.Err, errLengthMismatch):
+		fmt.Println(colorize(colorRed, fmt.Sprintf("✗ Truncated: %v", result.Err)))
+		c.stats.failed++
+		c.stats.truncated++
+	case result.Err != nil:
+		fmt.Println(colorize(colorRed, fmt.Sprintf("✗ Error: %v", result.Err)))
+		c.stats.failed++
+	default:
+		fmt.Println(colorize(colorGreen, fmt.Sprintf("✓ Downloaded: %s (%.2fMB)", filepath.Base(result.Path), float64(result.Size)/1024/1024)))
+		c.stats.success++
+		c.savedPaths = append(c.savedPaths, result.Path)
+		c.results[result.Path] = result
+	}
+}
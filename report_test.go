@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// The console statistics, metrics, manifest and summary agree on which
+// results are skips and which are failures
+func TestResultClassification(t *testing.T) {
+	errs := []error{
+		nil,
+		errAlreadyExists,
+		errTooSmall,
+		errNotModified,
+		errRobots,
+		errCircuitOpen,
+		fmt.Errorf("wrapped: %w", errNotImage),
+		fmt.Errorf("%w: bad huffman code", errCorruptImage),
+		fmt.Errorf("%w: received 1 of 2 bytes", errLengthMismatch),
+		errors.New("HTTP error: 404 Not Found"),
+	}
+	for _, err := range errs {
+		result := Result{URL: "https://example.com/a.jpg", Err: err}
+		if err == nil || errors.Is(err, errAlreadyExists) {
+			result.Path = "a.jpg"
+		}
+
+		reporter := &consoleReporter{metrics: newRunMetrics(), results: make(map[string]Result)}
+		reporter.onProgress(1, 1, result)
+		metrics := reporter.metrics
+		skipped := result.SkipReason() != SkipNone
+		failed := err != nil && !skipped
+
+		if got := metrics.skipped.Load() == 1; got != skipped {
+			t.Errorf("%v: metrics skipped = %v, manifest skip reason %q", err, got, result.SkipReason())
+		}
+		if got := metrics.failed.Load() == 1; got != failed {
+			t.Errorf("%v: metrics failed = %v, want %v", err, got, failed)
+		}
+		if got := reporter.stats.failed == 1; got != failed {
+			t.Errorf("%v: statistics failed = %v, want %v", err, got, failed)
+		}
+		if status := resultStatus(result); (status == "failed" || status == "corrupt" || status == "length_mismatch") != failed {
+			t.Errorf("%v: manifest status %q, failed = %v", err, status, failed)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Validators of a partially downloaded file, stored next to the .part file
+type partMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// Path of the .part file of an image saved at outputPath. It's named after a
+// hash of the URL rather than the image, so different URLs saved under the
+// same name never share a partial file, and long names can't overflow.
+func partPathFor(outputPath, imageURL string) string {
+	return filepath.Join(filepath.Dir(outputPath), "."+sha256Hex([]byte(imageURL))[:16]+".part")
+}
+
+func partMetaPath(partPath string) string {
+	return partPath + ".meta"
+}
+
+func readPartMeta(partPath string) (partMeta, bool) {
+	var meta partMeta
+	data, err := os.ReadFile(partMetaPath(partPath))
+	if err != nil {
+		return meta, false
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, false
+	}
+	return meta, true
+}
+
+func writePartMeta(partPath string, meta partMeta, modes fileModes) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return modes.writeFile(partMetaPath(partPath), data)
+}
+
+// Remove the .part file and its metadata after a completed download
+func removePartFiles(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partMetaPath(partPath))
+}
+
+// Add Range and If-Range headers to continue an interrupted download.
+// Returns the offset to resume from, or 0 if the download starts over.
+// A partial file is only resumed when a validator was stored for it, so the
+// server can tell us whether the resource changed in the meantime.
+func prepareResume(req *http.Request, partPath, imageURL string) int64 {
+	info, err := os.Stat(partPath)
+	if err != nil || info.Size() == 0 {
+		return 0
+	}
+
+	meta, ok := readPartMeta(partPath)
+	if !ok || meta.URL != imageURL {
+		return 0
+	}
+
+	// Weak ETags are not allowed in If-Range
+	validator := meta.ETag
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = meta.LastModified
+	}
+	if validator == "" {
+		return 0
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", info.Size()))
+	req.Header.Set("If-Range", validator)
+	return info.Size()
+}
+
+// Parse the start offset of a "bytes start-end/total" Content-Range header
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return offset, true
+}
+
+// Stream the response body into the .part file and return the complete data.
+// A 206 response is appended to the existing partial file; a 200 response
+// means the resource changed (or the server ignored the range), so the
+// partial file is replaced. The .part file is kept if the body is cut off.
+func readResumable(resp *http.Response, partPath, imageURL string, offset int64, modes fileModes) ([]byte, error) {
+	if err := modes.mkdirAll(filepath.Dir(partPath)); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resp.StatusCode == http.StatusPartialContent {
+		start, ok := contentRangeStart(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			removePartFiles(partPath)
+			return nil, fmt.Errorf("unexpected Content-Range %q for resume at %d bytes", resp.Header.Get("Content-Range"), offset)
+		}
+		fmt.Printf("  Resuming from %.2fMB\n", float64(offset)/1024/1024)
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	} else {
+		if offset > 0 {
+			fmt.Println("  Resource changed since last attempt, restarting download")
+		}
+		meta := partMeta{
+			URL:          imageURL,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		if err := writePartMeta(partPath, meta, modes); err != nil {
+			return nil, fmt.Errorf("failed to write resume metadata: %v", err)
+		}
+	}
+
+	partFile, err := modes.openFile(partPath, flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %v", err)
+	}
+	_, err = io.Copy(partFile, resp.Body)
+	closeErr := partFile.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	if closeErr != nil {
+		return nil, fmt.Errorf("failed to write file: %v", closeErr)
+	}
+
+	return os.ReadFile(partPath)
+}
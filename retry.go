@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	retryBaseDelay = time.Second     // First backoff delay, doubled on each attempt
+	maxRetryDelay  = 2 * time.Minute // Upper bound for any single wait
+)
+
+// Check whether an HTTP status is worth retrying
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Parse a Retry-After header in either delta-seconds or HTTP-date form
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	wait := date.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// Exponential backoff with random jitter, so concurrent clients hitting the
+// same server don't retry in lockstep
+func backoffDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	// Random delay between 50% and 150% of the schedule
+	return delay/2 + time.Duration(rand.Int63n(int64(delay)))
+}
+
+// Pick the wait before the next attempt, honoring Retry-After on 429 and 503
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			// Add up to 10% jitter on top of the server's requested wait
+			if wait > 0 {
+				wait += time.Duration(rand.Int63n(int64(wait)/10 + 1))
+			}
+			return min(wait, maxRetryDelay)
+		}
+	}
+	return backoffDelay(attempt)
+}
+
+// Copy a request with its URL pointed at a mirror host
+func requestForHost(req *http.Request, host string) *http.Request {
+	if host == req.URL.Host {
+		return req
+	}
+	mirrored := req.Clone(req.Context())
+	mirrored.URL.Host = host
+	// Keep an explicit Host header override, otherwise follow the mirror
+	if req.Host == req.URL.Host {
+		mirrored.Host = host
+	}
+	return mirrored
+}
+
+// Kind of failed attempt, each with its own retry limit
+type retryCause string
+
+const (
+	causeNetwork retryCause = "network error"
+	causeTimeout retryCause = "timeout"
+	causeStatus  retryCause = "HTTP status"
+)
+
+// Classify a failed attempt: err is set for network errors and timeouts,
+// otherwise the response had a retryable status
+func failureCause(err error) retryCause {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return causeStatus
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return causeTimeout
+	default:
+		return causeNetwork
+	}
+}
+
+// Retries allowed for one request, by cause of the failure
+type retryPolicy struct {
+	network int // Connection errors other than timeouts
+	timeout int // Timeouts connecting or waiting for the response
+	status  int // 429 and 5xx responses
+}
+
+func (p retryPolicy) limit(cause retryCause) int {
+	switch cause {
+	case causeTimeout:
+		return p.timeout
+	case causeStatus:
+		return p.status
+	default:
+		return p.network
+	}
+}
+
+// Check whether any failure is retried
+func (p retryPolicy) enabled() bool {
+	return p.network > 0 || p.timeout > 0 || p.status > 0
+}
+
+// Send a request, retrying network errors, timeouts and retryable statuses
+// as often as the policy allows for each. Each attempt tries the request's
+// host and then its mirrors in order, failing over immediately; the backoff
+// wait only happens once every host has failed. Retries stop early once the
+// host's share of budget is used up (nil = unlimited). The final response or
+// error is returned as is.
+func sendWithRetry(client Doer, req *http.Request, policy retryPolicy, mirrors []string, budget *retryBudget) (*http.Response, error) {
+	hosts := append([]string{req.URL.Host}, mirrors...)
+	used := make(map[retryCause]int)
+	for attempt := 0; ; attempt++ {
+		var resp *http.Response
+		var err error
+		for i, host := range hosts {
+			if i > 0 {
+				fmt.Printf("  Trying mirror %s...\n", host)
+			}
+			resp, err = client.Do(requestForHost(req, host))
+			if err == nil && !isRetryableStatus(resp.StatusCode) {
+				return resp, nil
+			}
+			if i < len(hosts)-1 {
+				if err != nil {
+					fmt.Printf("  Request to %s failed: %v\n", host, err)
+				} else {
+					fmt.Printf("  Request to %s failed: HTTP error %s\n", host, resp.Status)
+					resp.Body.Close()
+				}
+			}
+		}
+
+		cause := failureCause(err)
+		limit := policy.limit(cause)
+		if used[cause] >= limit {
+			if limit > 0 {
+				fmt.Printf("  No %s retries left (%d used), giving up\n", cause, limit)
+				budget.exhaust(cause)
+			}
+			return resp, err
+		}
+		if !budget.take(req.URL.Host) {
+			fmt.Printf("  Retry budget of %d for %s used up, not retrying\n", budget.max, req.URL.Host)
+			return resp, err
+		}
+		used[cause]++
+
+		delay := retryDelay(resp, attempt)
+		if err != nil {
+			fmt.Printf("  Request failed (%v), retrying in %.1fs (%s %d/%d)...\n", err, delay.Seconds(), cause, used[cause], limit)
+		} else {
+			resp.Body.Close()
+			fmt.Printf("  HTTP error %s, retrying in %.1fs (%s %d/%d)...\n", resp.Status, delay.Seconds(), cause, used[cause], limit)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Parse -mirror values of the form "primary=mirror1,mirror2"
+func parseMirrors(values []string) (map[string][]string, error) {
+	mirrors := make(map[string][]string)
+	for _, value := range values {
+		primary, list, ok := strings.Cut(value, "=")
+		primary = strings.TrimSpace(primary)
+		if !ok || primary == "" {
+			return nil, fmt.Errorf("invalid mirror %q, expected host=mirror1,mirror2", value)
+		}
+		for _, mirror := range strings.Split(list, ",") {
+			mirror = strings.TrimSpace(mirror)
+			if mirror == "" {
+				return nil, fmt.Errorf("invalid mirror %q, empty mirror host", value)
+			}
+			mirrors[primary] = append(mirrors[primary], mirror)
+		}
+	}
+	return mirrors, nil
+}
+
+// Cap on the total retries spent on each host, so a single bad host can't
+// consume the whole run retrying
+type retryBudget struct {
+	max int // Retries allowed per host (0 = unlimited)
+
+	mu        sync.Mutex
+	used      map[string]int
+	exhausted map[retryCause]int // Requests that failed after all retries of a cause
+}
+
+func newRetryBudget(max int) *retryBudget {
+	return &retryBudget{max: max, used: make(map[string]int), exhausted: make(map[retryCause]int)}
+}
+
+// Count a request that used up the retries for cause
+func (b *retryBudget) exhaust(cause retryCause) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.exhausted[cause]++
+}
+
+// Use one retry of host's budget. Returns false when none is left.
+func (b *retryBudget) take(host string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && b.used[host] >= b.max {
+		return false
+	}
+	b.used[host]++
+	return true
+}
+
+// Print the retries spent per host, most first
+func (b *retryBudget) print() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.used) == 0 {
+		return
+	}
+	hosts := make([]string, 0, len(b.used))
+	for host := range b.used {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if b.used[hosts[i]] != b.used[hosts[j]] {
+			return b.used[hosts[i]] > b.used[hosts[j]]
+		}
+		return hosts[i] < hosts[j]
+	})
+
+	fmt.Println("Retries per host:")
+	for _, host := range hosts {
+		if b.max > 0 {
+			fmt.Printf("  %s: %d/%d\n", host, b.used[host], b.max)
+		} else {
+			fmt.Printf("  %s: %d\n", host, b.used[host])
+		}
+	}
+
+	// Which kind of failure outlasted its retries
+	var exhausted []string
+	for _, cause := range []retryCause{causeTimeout, causeStatus, causeNetwork} {
+		if n := b.exhausted[cause]; n > 0 {
+			exhausted = append(exhausted, fmt.Sprintf("%s %d", cause, n))
+		}
+	}
+	if len(exhausted) > 0 {
+		fmt.Printf("Retries used up by: %s\n", strings.Join(exhausted, ", "))
+	}
+}
+
+// Per-host circuit breaker: after threshold consecutive failures, the host's
+// remaining URLs are skipped instead of waiting out every timeout
+type hostBreaker struct {
+	threshold int
+
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+func newHostBreaker(threshold int) *hostBreaker {
+	return &hostBreaker{threshold: threshold, failures: make(map[string]int)}
+}
+
+// Check whether requests to host should be skipped
+func (b *hostBreaker) open(host string) bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures[host] >= b.threshold
+}
+
+// Record the outcome of a download from host
+func (b *hostBreaker) record(host string, failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures[host] = 0
+		return
+	}
+	b.failures[host]++
+	if b.failures[host] == b.threshold {
+		fmt.Printf("  %d consecutive failures from %s, skipping its remaining images\n", b.threshold, host)
+	}
+}
+
+// Check whether a download error counts as a failure of its host. Skips and
+// rejected responses show the host is working.
+func isHostFailure(err error) bool {
+	return err != nil && !errors.Is(err, errAlreadyExists) && !errors.Is(err, errTooSmall) && !errors.Is(err, errNotModified) &&
+		!errors.Is(err, errCorruptImage) && !errors.Is(err, errNotImage)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// <img> and <source> tags, whose attributes are matched separately
+	htmlImageTagPattern = regexp.MustCompile(`(?is)<(img|source)\b[^>]*>`)
+	htmlAttrPattern     = regexp.MustCompile(`(?is)\b(src|srcset|data-src|data-srcset)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+	// ![alt](url) and ![alt](url "title"), the URL optionally in <>
+	markdownImagePattern = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^\s)>]+)>?(?:\s+(?:"[^"]*"|'[^']*'))?\s*\)`)
+)
+
+// Image links referenced by HTML <img> and <source> tags and Markdown image
+// syntax in a string value. Relative links are resolved against base; without
+// one they're left out and counted in relative.
+func richTextImageURLs(s string, base *url.URL) (urls []string, relative int) {
+	if !strings.Contains(s, "<") && !strings.Contains(s, "![") {
+		return nil, 0
+	}
+
+	var refs []string
+	for _, tag := range htmlImageTagPattern.FindAllStringSubmatch(s, -1) {
+		isSource := strings.EqualFold(tag[1], "source")
+		for _, attr := range htmlAttrPattern.FindAllStringSubmatch(tag[0], -1) {
+			name := strings.ToLower(attr[1])
+			value := html.UnescapeString(attr[2] + attr[3] + attr[4])
+			switch {
+			case strings.HasSuffix(name, "srcset"):
+				refs = append(refs, srcsetURLs(value)...)
+			case !isSource:
+				// <source src> belongs to <video> and <audio>, not images
+				refs = append(refs, strings.TrimSpace(value))
+			}
+		}
+	}
+	for _, match := range markdownImagePattern.FindAllStringSubmatch(s, -1) {
+		refs = append(refs, match[1])
+	}
+
+	for _, ref := range refs {
+		if ref == "" || strings.HasPrefix(ref, "data:") {
+			continue
+		}
+		u, err := url.Parse(ref)
+		if err != nil {
+			continue
+		}
+		if !u.IsAbs() {
+			if base == nil {
+				relative++
+				continue
+			}
+			u = base.ResolveReference(u)
+		}
+		if u.Scheme == "http" || u.Scheme == "https" {
+			urls = append(urls, u.String())
+		}
+	}
+	return urls, relative
+}
+
+// URLs of the candidates of a srcset attribute, which unlike srcset JSON
+// values may be relative
+func srcsetURLs(value string) []string {
+	var urls []string
+	for _, candidate := range strings.Split(value, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			urls = append(urls, fields[0])
+		}
+	}
+	return urls
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Product token matched against robots.txt User-agent lines. Groups for
+// "*" apply when no group names it.
+const robotsAgent = "json-shake"
+
+// Largest robots.txt read, the limit RFC 9309 asks crawlers to parse
+const maxRobotsBytes = 500 * 1024
+
+// One Allow or Disallow line
+type robotsRule struct {
+	allow   bool
+	length  int // Pattern length, the longest matching rule wins
+	pattern *regexp.Regexp
+}
+
+// Rules of the robots.txt group that applies to us
+type robotsRules []robotsRule
+
+// Rules of a host whose robots.txt is unreachable
+var disallowAll = robotsRules{{length: 1, pattern: robotsPattern("/")}}
+
+// Parse a robots.txt file, keeping the rules of the group naming agent or,
+// without one, of the "*" group
+func parseRobots(data []byte, agent string) robotsRules {
+	var specific, wildcard robotsRules
+	var agents []string
+	inRules := false // Whether the current group's User-agent lines ended
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// A User-agent line after rules starts a new group
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue // An empty Disallow allows everything
+			}
+			rule := robotsRule{allow: key == "allow", length: len(value), pattern: robotsPattern(value)}
+			for _, a := range agents {
+				switch {
+				case a == "*":
+					wildcard = append(wildcard, rule)
+				case a == strings.ToLower(agent):
+					specific = append(specific, rule)
+				}
+			}
+		}
+	}
+	if specific != nil {
+		return specific
+	}
+	return wildcard
+}
+
+// Compile a robots.txt path pattern, where * matches any characters and a
+// trailing $ anchors the end of the path
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// Check whether a path (with query) may be fetched. The longest matching
+// rule decides, and Allow wins ties.
+func (r robotsRules) allowed(path string) bool {
+	best := -1
+	allow := true
+	for _, rule := range r {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > best || (rule.length == best && rule.allow) {
+			best, allow = rule.length, rule.allow
+		}
+	}
+	return allow
+}
+
+// robots.txt rules fetched once per host
+type robotsCache struct {
+	client Doer
+
+	mu    sync.Mutex
+	hosts map[string]*robotsHost
+}
+
+// Rules of one host. Only the first image of the host fetches them; the
+// others wait for it, while other hosts go on.
+type robotsHost struct {
+	once  sync.Once
+	rules robotsRules
+}
+
+func newRobotsCache(client Doer) *robotsCache {
+	return &robotsCache{client: client, hosts: make(map[string]*robotsHost)}
+}
+
+// Check whether robots.txt allows fetching imageURL, fetching the host's
+// rules on first use
+func (c *robotsCache) allowed(imageURL string) bool {
+	u, err := url.Parse(imageURL)
+	if err != nil || u.Host == "" {
+		return true
+	}
+	key := u.Scheme + "://" + u.Host
+	c.mu.Lock()
+	host, ok := c.hosts[key]
+	if !ok {
+		host = &robotsHost{}
+		c.hosts[key] = host
+	}
+	c.mu.Unlock()
+	host.once.Do(func() { host.rules = c.fetch(key) })
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return host.rules.allowed(path)
+}
+
+// Fetch the rules of a host. A missing robots.txt (4xx) allows everything.
+// One that is unreachable because of a server (5xx) or network error
+// disallows everything, as RFC 9309 asks, with a warning.
+func (c *robotsCache) fetch(origin string) robotsRules {
+	req, err := http.NewRequest(http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		warnf("Warning: failed to fetch %s/robots.txt, skipping all its images: %v", origin, err)
+		return disallowAll
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return nil
+	case resp.StatusCode >= 500:
+		warnf("Warning: %s/robots.txt returned %s, skipping all its images", origin, resp.Status)
+		return disallowAll
+	case resp.StatusCode != http.StatusOK:
+		warnf("Warning: %s/robots.txt returned %s, allowing all images", origin, resp.Status)
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsBytes))
+	if err != nil {
+		warnf("Warning: failed to read %s/robots.txt, skipping all its images: %v", origin, err)
+		return disallowAll
+	}
+	rules := parseRobots(data, robotsAgent)
+	fmt.Fprintf(statusOut, "Loaded %s/robots.txt (%d rules)\n", origin, len(rules))
+	return rules
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRobotsCacheStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		allowed map[string]bool // Path -> whether it may be fetched
+	}{
+		{"rules", http.StatusOK, "User-agent: *\nDisallow: /private/\n", map[string]bool{"/a.jpg": true, "/private/a.jpg": false}},
+		{"missing", http.StatusNotFound, "", map[string]bool{"/a.jpg": true, "/private/a.jpg": true}},
+		{"forbidden", http.StatusForbidden, "", map[string]bool{"/a.jpg": true}},
+		{"server error", http.StatusInternalServerError, "", map[string]bool{"/a.jpg": false, "/": false}},
+		{"unavailable", http.StatusServiceUnavailable, "", map[string]bool{"/a.jpg": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			cache := newRobotsCache(server.Client())
+			for path, want := range tt.allowed {
+				if got := cache.allowed(server.URL + path); got != want {
+					t.Errorf("allowed(%s) = %v, want %v", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+// A network error disallows everything too
+func TestRobotsCacheUnreachable(t *testing.T) {
+	client := doerFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+	if newRobotsCache(client).allowed("https://example.com/a.jpg") {
+		t.Error("allowed = true with an unreachable robots.txt, want false")
+	}
+}
+
+// A host with a slow robots.txt doesn't hold up the images of other hosts,
+// and its robots.txt is fetched only once
+func TestRobotsCacheSlowHost(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var slowFetches atomic.Int32
+	client := doerFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "slow.example.com" {
+			if slowFetches.Add(1) == 1 {
+				close(started)
+			}
+			<-release
+		}
+		return &http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody}, nil
+	})
+	cache := newRobotsCache(client)
+
+	slowDone := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() { slowDone <- cache.allowed("https://slow.example.com/a.jpg") }()
+	}
+
+	<-started
+	fastDone := make(chan bool)
+	go func() { fastDone <- cache.allowed("https://fast.example.com/a.jpg") }()
+	select {
+	case <-fastDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fast.example.com waited for the robots.txt of slow.example.com")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if !<-slowDone {
+			t.Error("slow.example.com allowed = false, want true")
+		}
+	}
+	if n := slowFetches.Load(); n != 1 {
+		t.Errorf("robots.txt of slow.example.com fetched %d times, want once", n)
+	}
+}
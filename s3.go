@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3-compatible bucket that images are uploaded to instead of ~/Downloads
+type s3Store struct {
+	bucket       string
+	prefix       string
+	endpoint     string // Custom endpoint (MinIO etc.), uses path-style URLs
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// Parse an s3://bucket/prefix target and read credentials from the
+// standard AWS environment variables
+func newS3Store(target, endpoint, region string) (*s3Store, error) {
+	rest, ok := strings.CutPrefix(target, "s3://")
+	if !ok {
+		return nil, fmt.Errorf("invalid S3 target: %s (expected s3://bucket/prefix)", target)
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid S3 target: %s (missing bucket)", target)
+	}
+
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	store := &s3Store{
+		bucket:       bucket,
+		prefix:       strings.Trim(prefix, "/"),
+		endpoint:     strings.TrimRight(endpoint, "/"),
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 60 * time.Second},
+	}
+	if store.accessKey == "" || store.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for S3 output")
+	}
+	return store, nil
+}
+
+// Sink writing under prefix/name in the bucket
+func (s *s3Store) sink(name string) Sink {
+	return &s3Sink{store: s, prefix: path.Join(s.prefix, name)}
+}
+
+// Build the URL of an object key
+func (s *s3Store) objectURL(key string) string {
+	escaped := uriEncode("/" + key)
+	if s.endpoint != "" {
+		return s.endpoint + "/" + s.bucket + escaped
+	}
+	return "https://" + s.bucket + ".s3." + s.region + ".amazonaws.com" + escaped
+}
+
+// Send a request for an object key signed with AWS Signature Version 4
+func (s *s3Store) do(method, key string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+func (s *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+
+	// Canonical request over host and the x-amz-* headers
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if s.sessionToken != "" {
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + s.sessionToken + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// Percent-encode a path as UriEncode of Signature Version 4 does: everything
+// but A-Z, a-z, 0-9, "-", ".", "_", "~" and "/" is escaped. The escaping of
+// url.URL leaves characters like "@", "+" and "=" as they are, which S3
+// signs differently.
+func uriEncode(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Sink uploading objects below a key prefix
+type s3Sink struct {
+	store  *s3Store
+	prefix string
+}
+
+func (s *s3Sink) key(p string) string {
+	return path.Join(s.prefix, p)
+}
+
+func (s *s3Sink) Location(p string) string {
+	return "s3://" + s.store.bucket + "/" + s.key(p)
+}
+
+func (s *s3Sink) Stat(p string) (int64, bool, error) {
+	resp, err := s.store.do(http.MethodHead, s.key(p), nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		return size, true, nil
+	case http.StatusNotFound:
+		return 0, false, nil
+	default:
+		return 0, false, fmt.Errorf("S3 HEAD returned status code: %d", resp.StatusCode)
+	}
+}
+
+func (s *s3Sink) Write(p string, data []byte) error {
+	resp, err := s.store.do(http.MethodPut, s.key(p), data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("S3 PUT returned status code: %d %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Characters not allowed in Windows filenames
+const reservedFilenameChars = `/\:*?"<>|`
+
+// Device names Windows reserves for any file, with or without an extension
+var reservedDeviceNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Make a filename valid on Windows, macOS and Linux alike. Reserved and
+// control characters and invalid UTF-8 become underscores, trailing spaces
+// and dots (which Windows drops) are removed, and reserved device names like
+// CON or nul.txt get an underscore prefix. Unicode letters are kept. Returns
+// "" if nothing usable is left.
+func sanitizeFilename(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(reservedFilenameChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, " .")
+	if name == "" {
+		return ""
+	}
+
+	// Windows ignores the extension and trailing spaces when matching
+	// device names, so "con.jpg" and "AUX .png" are reserved too
+	stem, _, _ := strings.Cut(name, ".")
+	if reservedDeviceNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		name = "_" + name
+	}
+	return name
+}
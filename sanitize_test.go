@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"Фото 日本.png", "Фото 日本.png"},
+
+		// Reserved device names, in any case and with any extension
+		{"CON", "_CON"},
+		{"con", "_con"},
+		{"nul.txt", "_nul.txt"},
+		{"NUL.tar.gz", "_NUL.tar.gz"},
+		{"AUX .png", "_AUX .png"},
+		{"com1.jpg", "_com1.jpg"},
+		{"LPT9", "_LPT9"},
+		{"COM0.jpg", "COM0.jpg"},
+		{"CONSOLE.jpg", "CONSOLE.jpg"},
+		{"icon.jpg", "icon.jpg"},
+
+		// Trailing dots and spaces, which Windows drops
+		{"photo.jpg.", "photo.jpg"},
+		{"photo.jpg ", "photo.jpg"},
+		{"photo. . .", "photo"},
+		{"con. ", "_con"},
+		{"...", ""},
+		{"   ", ""},
+
+		// Reserved and control characters
+		{`a<b>c:d"e|f?g*h\i.jpg`, "a_b_c_d_e_f_g_h_i.jpg"},
+		{"tab\there\x00.jpg", "tab_here_.jpg"},
+		{"bad\xffutf8.jpg", "bad_utf8.jpg"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeFilename(tt.name); got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// A built-in extraction sample: the links expected from a JSON document,
+// with the extraction options it needs
+type selftestCase struct {
+	name  string
+	flags string // Options as given on the command line, for display
+	eopts ExtractOptions
+	json  string
+	want  []string
+}
+
+// Samples covering the matcher's behavior, including what it leaves out
+var selftestCases = []selftestCase{
+	{
+		name: "nested objects",
+		json: `{"product": {"media": {"hero": {"src": "https://cdn.example.com/hero.jpg"}}}}`,
+		want: []string{"https://cdn.example.com/hero.jpg"},
+	},
+	{
+		name: "arrays of links and objects",
+		json: `{"gallery": ["https://example.com/1.png", {"url": "https://example.com/2.gif"}, [["https://example.com/3.webp"]]]}`,
+		want: []string{"https://example.com/1.png", "https://example.com/2.gif", "https://example.com/3.webp"},
+	},
+	{
+		name: "links inside text",
+		json: `{"description": "Front: https://example.com/front.jpg, back: https://example.com/back.png."}`,
+		want: []string{"https://example.com/front.jpg", "https://example.com/back.png"},
+	},
+	{
+		name: "escaped slashes",
+		json: `{"image": "https:\/\/example.com\/photos\/cover.jpg"}`,
+		want: []string{"https://example.com/photos/cover.jpg"},
+	},
+	{
+		name: "uppercase extensions only match with an image keyword",
+		json: `{"a": "https://example.com/photos/IMG_0001.JPG", "b": "https://example.com/t/SCAN.JPEG"}`,
+		want: []string{"https://example.com/photos/IMG_0001.JPG"},
+	},
+	{
+		name: "query strings are kept, fragments dropped",
+		json: `{"a": "https://example.com/a.jpg?w=800&h=600", "b": "https://example.com/b.png#x"}`,
+		want: []string{"https://example.com/a.jpg?w=800&h=600", "https://example.com/b.png"},
+	},
+	{
+		name: "links without extension, taken by image keywords",
+		json: `{"avatar": "https://images.example.com/u/12345", "zip": "https://example.com/images.zip", "page": "https://example.com/about"}`,
+		want: []string{"https://images.example.com/u/12345", "https://example.com/images.zip"},
+	},
+	{
+		name: "data URIs are skipped",
+		json: `{"placeholder": "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="}`,
+	},
+	{
+		name: "mislabeled extensions are cut at the image extension",
+		json: `{"page": "https://example.com/photo.jpg.html", "doc": "https://example.com/scan.pdf", "svg": "https://example.com/logo.svgz"}`,
+		want: []string{"https://example.com/photo.jpg", "https://example.com/logo.svg"},
+	},
+	{
+		name: "srcset values",
+		json: `{"srcset": "https://example.com/s.jpg 480w, https://example.com/m.jpg 800w, https://example.com/l.jpg 1200w"}`,
+		want: []string{"https://example.com/s.jpg", "https://example.com/m.jpg", "https://example.com/l.jpg"},
+	},
+	{
+		name:  "srcset values, largest candidate",
+		flags: "-srcset-prefer largest",
+		eopts: ExtractOptions{SrcsetPrefer: srcsetLargest},
+		json:  `{"srcset": "https://example.com/s.jpg 480w, https://example.com/l.jpg 1200w, https://example.com/m.jpg 800w"}`,
+		want:  []string{"https://example.com/l.jpg"},
+	},
+	{
+		name:  "HTML and Markdown images",
+		flags: "-parse-html -base-url https://example.com/blog/",
+		eopts: ExtractOptions{ParseHTML: true, BaseURL: "https://example.com/blog/"},
+		json:  `{"body": "<p><img src=\"cover.png\" alt=\"\"></p> and ![chart](https://example.com/chart.svg)"}`,
+		want:  []string{"https://example.com/blog/cover.png", "https://example.com/chart.svg"},
+	},
+	{
+		name: "duplicates are reported once",
+		json: `{"a": "https://example.com/same.jpg", "b": ["https://example.com/same.jpg"]}`,
+		want: []string{"https://example.com/same.jpg"},
+	},
+}
+
+// Print usage of the selftest subcommand
+func printSelftestUsage() {
+	fmt.Println("Usage: json-shake selftest [options]")
+	fmt.Println("Run the link extractor on built-in JSON samples and report the links found in each.")
+	fmt.Println("Options:")
+	fmt.Println("  -q                   Only print failed samples and the result")
+	fmt.Println("  -bench <n>           Also extract all samples n times and report the time per sample")
+}
+
+// Check the extractor against the built-in samples, as a smoke test of a
+// build and a reference of what is matched. Exits with status 1 if any
+// sample doesn't give the expected links.
+func runSelftest(args []string) {
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	flags.Usage = printSelftestUsage
+
+	var quiet bool
+	var benchRuns int
+	flags.BoolVar(&quiet, "q", false, "Only print failed samples and the result")
+	flags.IntVar(&benchRuns, "bench", 0, "Also extract all samples this many times and report the time per sample")
+	showVersion := flags.Bool("version", false, "Print the version and supported formats, then exit")
+	flags.Parse(args)
+	if *showVersion {
+		printVersion()
+		return
+	}
+
+	if flags.NArg() > 0 || benchRuns < 0 {
+		printSelftestUsage()
+		os.Exit(1)
+	}
+
+	failed := 0
+	for i, c := range selftestCases {
+		got, err := c.extract()
+		missing, unexpected := diffLinks(c.want, got)
+		ok := err == nil && len(missing) == 0 && len(unexpected) == 0
+		if !ok {
+			failed++
+		}
+		if ok && quiet {
+			continue
+		}
+
+		title := fmt.Sprintf("[%d/%d] %s", i+1, len(selftestCases), c.name)
+		if c.flags != "" {
+			title += " (" + c.flags + ")"
+		}
+		if ok {
+			fmt.Println(colorize(colorGreen, "✓ "+title))
+		} else {
+			fmt.Println(colorize(colorRed, "✗ "+title))
+		}
+		fmt.Printf("  Input: %s\n", c.json)
+		if err != nil {
+			fmt.Printf("  Error: %v\n", err)
+			continue
+		}
+		if len(got) == 0 {
+			fmt.Println("  Found: none")
+		}
+		for _, u := range got {
+			fmt.Printf("  Found: %s\n", u)
+		}
+		for _, u := range missing {
+			fmt.Printf("  Missing: %s\n", u)
+		}
+		for _, u := range unexpected {
+			fmt.Printf("  Unexpected: %s\n", u)
+		}
+	}
+
+	if benchRuns > 0 {
+		start := time.Now()
+		for n := 0; n < benchRuns; n++ {
+			for _, c := range selftestCases {
+				c.extract()
+			}
+		}
+		perSample := time.Since(start) / time.Duration(benchRuns*len(selftestCases))
+		fmt.Printf("\nExtracted %d samples %d times: %v per sample\n", len(selftestCases), benchRuns, perSample)
+	}
+
+	fmt.Printf("\nSelf-test: %d passed, %d failed\n", len(selftestCases)-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Extract the deduplicated links of a sample, as the extract command would
+func (c selftestCase) extract() ([]string, error) {
+	data, err := parseJSON([]byte(c.json))
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	extractImageURLs(data, c.eopts.filter(), &urls)
+	return dedupeURLs(urls), nil
+}
+
+// Links expected but not found, and found but not expected, each sorted
+func diffLinks(want, got []string) (missing, unexpected []string) {
+	wanted := make(map[string]bool)
+	for _, u := range want {
+		wanted[u] = true
+	}
+	found := make(map[string]bool)
+	for _, u := range got {
+		found[u] = true
+		if !wanted[u] {
+			unexpected = append(unexpected, u)
+		}
+	}
+	for _, u := range want {
+		if !found[u] {
+			missing = append(missing, u)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unexpected)
+	return missing, unexpected
+}
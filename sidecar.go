@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Extension appended to an image's filename for its -sidecar file
+const sidecarExt = ".json"
+
+// Extension appended to an image's filename for its -save-headers file
+const headersExt = ".headers"
+
+// Provenance of one saved image, written next to it by -sidecar
+type sidecar struct {
+	URL          string    `json:"url"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	ContentType  string    `json:"content_type,omitempty"`
+	OriginalSize int64     `json:"original_size"` // Bytes as downloaded
+	Size         int64     `json:"size"`          // Bytes saved
+
+	// Changes made to the download, in order, e.g. "compressed"
+	Transformations []string `json:"transformations,omitempty"`
+}
+
+// Write the sidecar of a saved image, e.g. photo.jpg.json for photo.jpg
+func writeSidecar(sink Sink, img *pendingImage) error {
+	data, err := json.MarshalIndent(sidecar{
+		URL:             img.url,
+		DownloadedAt:    img.fetched,
+		ContentType:     img.contentType,
+		OriginalSize:    int64(len(img.downloaded)),
+		Size:            int64(len(img.data)),
+		Transformations: img.transforms,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return sink.Write(img.outputPath+sidecarExt, append(data, '\n'))
+}
+
+// Check whether a path is the sidecar of an image, like photo.jpg.json, so
+// input patterns such as *.json don't pick up the output of earlier runs
+func isSidecarPath(p string) bool {
+	if !strings.EqualFold(filepath.Ext(p), sidecarExt) {
+		return false
+	}
+	return isImageExtension(filepath.Ext(strings.TrimSuffix(p, filepath.Ext(p))))
+}
+
+// Status line, headers and trailers of a response in HTTP/1.1 wire format,
+// for the -save-headers file of its image. Trailers are only known once the
+// body has been read.
+func formatResponseHeaders(resp *http.Response) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&buf)
+	if len(resp.Trailer) > 0 {
+		buf.WriteString("\r\n")
+		resp.Trailer.Write(&buf)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Policies for images without a Last-Modified header under -since
+const (
+	sinceMissingDownload = "download"
+	sinceMissingSkip     = "skip"
+)
+
+// Parse a -since date: a date, a date and time, or RFC 3339. Dates without
+// a time zone are in local time.
+func parseSinceDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (expected e.g. 2024-05-01, 2024-05-01 12:00 or RFC 3339)", value)
+}
+
+// Check the Last-Modified header of a response against -since. Returns
+// errNotModified for images that are too old.
+func checkModifiedSince(resp *http.Response, since time.Time, missingPolicy string) error {
+	header := resp.Header.Get("Last-Modified")
+	if header == "" {
+		if missingPolicy == sinceMissingSkip {
+			return fmt.Errorf("%w: no Last-Modified header", errNotModified)
+		}
+		return nil
+	}
+
+	modified, err := http.ParseTime(header)
+	if err != nil {
+		if missingPolicy == sinceMissingSkip {
+			return fmt.Errorf("%w: invalid Last-Modified header %q", errNotModified, header)
+		}
+		return nil
+	}
+	if !modified.After(since) {
+		return fmt.Errorf("%w: last modified %s", errNotModified, modified.Local().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
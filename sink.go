@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// Sink is where downloaded images are saved. Paths are relative and
+// slash-separated, e.g. "cdn.example.com/photo.jpg".
+type Sink interface {
+	// Stat reports whether path already exists and its size
+	Stat(path string) (size int64, exists bool, err error)
+	// Write stores data at path, replacing any existing content
+	Write(path string, data []byte) error
+	// Location returns the full path or URL of path, shown to the user
+	Location(path string) string
+}
+
+// Sink writing into a local directory
+type dirSink struct {
+	dir   string
+	modes fileModes
+}
+
+func newDirSink(dir string, modes fileModes) *dirSink {
+	return &dirSink{dir: dir, modes: modes}
+}
+
+func (s *dirSink) Location(path string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(path))
+}
+
+func (s *dirSink) Stat(path string) (int64, bool, error) {
+	info, err := os.Stat(s.Location(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return info.Size(), true, nil
+}
+
+// Counter making temporary filenames unique within the process
+var tempFileCounter atomic.Uint64
+
+// Locks serializing the final rename into each destination path. Paths
+// share a fixed number of locks by hash, so none are kept per path.
+var destLocks [64]sync.Mutex
+
+func destLock(fullPath string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(fullPath))
+	return &destLocks[h.Sum32()%uint32(len(destLocks))]
+}
+
+// Write the data to a temporary file with a name unique to this process and
+// write, then rename it into place, so concurrent writes to the same
+// destination never interleave and readers never see a partial file. The
+// temporary name doesn't depend on the destination, which may already use
+// the whole filename length.
+func (s *dirSink) Write(path string, data []byte) error {
+	fullPath := s.Location(path)
+
+	// Create layout subdirectory if needed
+	if err := s.modes.mkdirAll(filepath.Dir(fullPath)); err != nil {
+		return err
+	}
+
+	tempPath := filepath.Join(filepath.Dir(fullPath), fmt.Sprintf(".%d-%d.tmp", os.Getpid(), tempFileCounter.Add(1)))
+	if err := s.modes.writeFile(tempPath, data); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	lock := destLock(fullPath)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := os.Rename(tempPath, fullPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Concurrent writes to one path leave exactly one of the written files,
+// never a mix, and no temporary files
+func TestDirSinkConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	sink := newDirSink(dir, fileModes{})
+
+	const writers = 16
+	contents := make([][]byte, writers)
+	var wg sync.WaitGroup
+	for i := range contents {
+		contents[i] = bytes.Repeat([]byte{byte('a' + i)}, 64*1024+i)
+		wg.Add(1)
+		go func(data []byte) {
+			defer wg.Done()
+			if err := sink.Write("sub/photo.jpg", data); err != nil {
+				t.Error(err)
+			}
+		}(contents[i])
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(filepath.Join(dir, "sub", "photo.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, want := range contents {
+		found = found || bytes.Equal(data, want)
+	}
+	if !found {
+		t.Errorf("photo.jpg holds %d bytes that match none of the writes", len(data))
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want only photo.jpg", len(entries))
+	}
+}
+
+// Names shortened by fitFilename can be written with the sidecar and
+// headers files next to them
+func TestDirSinkLongNames(t *testing.T) {
+	sink := newDirSink(t.TempDir(), fileModes{})
+	name := fitFilename(strings.Repeat("x", 400) + ".jpg")
+	for _, p := range []string{name, name + sidecarExt, name + headersExt} {
+		if err := sink.Write(p, []byte("data")); err != nil {
+			t.Errorf("writing %d-byte name: %v", len(p), err)
+		}
+	}
+
+	// The limit of the filesystem itself is usable too
+	if err := sink.Write(strings.Repeat("y", 251)+".jpg", []byte("data")); err != nil {
+		t.Errorf("writing 255-byte name: %v", err)
+	}
+}
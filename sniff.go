@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Bytes inspected to detect the image format by default. Content type
+// detection looks at the first 512 bytes; SVG files may start with a longer
+// XML prolog or comment before their <svg> tag.
+const defaultSniffBytes = 1024
+
+// Smallest -sniff-bytes value, the buffer size bufio requires and enough
+// for every image signature
+const minSniffBytes = 16
+
+// Number of bytes used to detect the format of a download
+func (o *Options) sniffBytes() int {
+	if o.SniffBytes > 0 {
+		return o.SniffBytes
+	}
+	return defaultSniffBytes
+}
+
+// Buffer the first n bytes of body so they can be inspected before the
+// body is read. The returned reader still yields those bytes, so nothing is
+// read twice. head is shorter than n for smaller bodies.
+func peekBody(body io.Reader, n int) (r *bufio.Reader, head []byte, err error) {
+	r = bufio.NewReaderSize(body, n)
+	head, err = r.Peek(n)
+	if err == io.EOF {
+		err = nil
+	}
+	return r, head, err
+}
+
+// Report whether the first bytes of a download look like an image
+func looksLikeImage(head []byte) bool {
+	return sniffExtension(head) != "" || bytes.Contains(head, []byte("<svg"))
+}
+
+// Check whether s is an http(s) URL without a file extension, a link that
+// may or may not be an image and can only be told apart by fetching it
+func isSniffCandidate(s string) bool {
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		return false
+	}
+	if strings.ContainsAny(s, " \t\r\n") {
+		return false
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return path.Ext(u.Path) == ""
+}
+
+// Whether extensionless links are images, decided by fetching their first
+// bytes once per URL
+type sniffCache struct {
+	mu      sync.Mutex
+	results map[string]bool
+	checked int // Links fetched
+	images  int // Links found to be images
+}
+
+func newSniffCache() *sniffCache {
+	return &sniffCache{results: make(map[string]bool)}
+}
+
+// Check whether imageURL serves an image, with a ranged GET for the first
+// defaultSniffBytes bytes. An image Content-Type or image magic bytes
+// decide; failed requests count as not an image.
+func (c *sniffCache) isImage(client Doer, imageURL string) bool {
+	c.mu.Lock()
+	isImage, ok := c.results[imageURL]
+	c.mu.Unlock()
+	if ok {
+		return isImage
+	}
+
+	isImage = sniffURL(client, imageURL)
+
+	c.mu.Lock()
+	if _, ok := c.results[imageURL]; !ok {
+		c.results[imageURL] = isImage
+		c.checked++
+		if isImage {
+			c.images++
+		}
+	}
+	c.mu.Unlock()
+	return isImage
+}
+
+// Counts of links fetched and found to be images so far
+func (c *sniffCache) counts() (checked, images int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checked, c.images
+}
+
+func sniffURL(client Doer, imageURL string) bool {
+	req, err := http.NewRequest(http.MethodGet, imageURL, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", defaultSniffBytes-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	// Servers ignoring the range send the whole body, which is only read
+	// up to the sniffed bytes
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return false
+	}
+	if getExtensionFromContentType(resp.Header.Get("Content-Type")) != "" {
+		return true
+	}
+	head, err := io.ReadAll(io.LimitReader(resp.Body, defaultSniffBytes))
+	if err != nil {
+		return false
+	}
+	return looksLikeImage(head)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Values of -srcset-prefer
+const (
+	srcsetAll     = "all"
+	srcsetLargest = "largest"
+)
+
+// One image candidate of an HTML srcset value
+type srcsetCandidate struct {
+	url        string
+	descriptor string // e.g. "2x" or "640w", empty for none
+}
+
+var srcsetDescriptorPattern = regexp.MustCompile(`^(?:\d+w|\d+(?:\.\d+)?x)$`)
+
+// Parse a srcset value such as "a.jpg 1x, a@2x.jpg 2x". Returns false for
+// strings that aren't srcset lists of absolute URLs with at least one
+// width or density descriptor.
+func parseSrcset(s string) ([]srcsetCandidate, bool) {
+	var candidates []srcsetCandidate
+	hasDescriptor := false
+	rest := s
+	for {
+		rest = strings.TrimLeft(rest, " \t\n\r\f,")
+		if rest == "" {
+			break
+		}
+
+		// The URL runs up to whitespace; trailing commas end the candidate
+		end := strings.IndexAny(rest, " \t\n\r\f")
+		if end < 0 {
+			end = len(rest)
+		}
+		candidate := srcsetCandidate{url: rest[:end]}
+		rest = rest[end:]
+		if trimmed := strings.TrimRight(candidate.url, ","); trimmed != candidate.url {
+			candidate.url = trimmed
+		} else {
+			// The descriptor runs up to the next comma
+			descriptor := rest
+			if comma := strings.IndexByte(rest, ','); comma >= 0 {
+				descriptor, rest = rest[:comma], rest[comma:]
+			} else {
+				rest = ""
+			}
+			candidate.descriptor = strings.TrimSpace(descriptor)
+		}
+
+		if !strings.HasPrefix(candidate.url, "http://") && !strings.HasPrefix(candidate.url, "https://") {
+			return nil, false
+		}
+		if candidate.descriptor != "" {
+			if !srcsetDescriptorPattern.MatchString(candidate.descriptor) {
+				return nil, false
+			}
+			hasDescriptor = true
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, hasDescriptor
+}
+
+// Size of a candidate for picking the largest: widths rank above
+// densities, and a missing descriptor means 1x
+func (c srcsetCandidate) size() (isWidth bool, value float64) {
+	if c.descriptor == "" {
+		return false, 1
+	}
+	value, _ = strconv.ParseFloat(c.descriptor[:len(c.descriptor)-1], 64)
+	return strings.HasSuffix(c.descriptor, "w"), value
+}
+
+// The candidate with the largest width, or density if there are no widths
+func largestSrcsetCandidate(candidates []srcsetCandidate) srcsetCandidate {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		bestWidth, bestValue := best.size()
+		width, value := c.size()
+		if (width && !bestWidth) || (width == bestWidth && value > bestValue) {
+			best = c
+		}
+	}
+	return best
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcome counts of a group of images, keyed by manifest status
+type summaryCounts struct {
+	Total    int                `json:"total"`
+	Statuses map[string]int     `json:"statuses"`
+	Skipped  map[SkipReason]int `json:"skipped,omitempty"` // Images not downloaded, by reason
+	Bytes    int64              `json:"bytes"`             // Bytes written for downloaded images
+}
+
+func (c *summaryCounts) add(result Result) {
+	if c.Statuses == nil {
+		c.Statuses = make(map[string]int)
+	}
+	status := resultStatus(result)
+	c.Total++
+	c.Statuses[status]++
+	if reason := result.SkipReason(); reason != SkipNone {
+		if c.Skipped == nil {
+			c.Skipped = make(map[SkipReason]int)
+		}
+		c.Skipped[reason]++
+	}
+	if status == "downloaded" {
+		c.Bytes += result.Size
+	}
+}
+
+// Undo add for a result counted before
+func (c *summaryCounts) remove(result Result) {
+	status := resultStatus(result)
+	c.Total--
+	if c.Statuses[status]--; c.Statuses[status] == 0 {
+		delete(c.Statuses, status)
+	}
+	if reason := result.SkipReason(); reason != SkipNone {
+		if c.Skipped[reason]--; c.Skipped[reason] == 0 {
+			delete(c.Skipped, reason)
+		}
+	}
+	if status == "downloaded" {
+		c.Bytes -= result.Size
+	}
+}
+
+// Aggregate report of a run written by -summary, without per-image entries
+type runSummary struct {
+	mu sync.Mutex
+
+	Started         time.Time                 `json:"started"`
+	Finished        time.Time                 `json:"finished"`
+	DurationSeconds float64                   `json:"duration_seconds"`
+	summaryCounts                             // Totals over all images
+	ByHost          map[string]*summaryCounts `json:"by_host"`
+	ByFormat        map[string]*summaryCounts `json:"by_format"`
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{
+		Started:       time.Now(),
+		summaryCounts: summaryCounts{Statuses: make(map[string]int)},
+		ByHost:        make(map[string]*summaryCounts),
+		ByFormat:      make(map[string]*summaryCounts),
+	}
+}
+
+// Count the outcome of one image. Safe to call on a nil summary.
+func (s *runSummary) record(result Result) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, counts := range s.countsFor(result) {
+		counts.add(result)
+	}
+}
+
+// Change the outcome of an image counted by record, like a downloaded image
+// deleted by -perceptual-dedup. Safe to call on a nil summary.
+func (s *runSummary) reclassify(before, after Result) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, counts := range s.countsFor(before) {
+		counts.remove(before)
+	}
+	for _, counts := range s.countsFor(after) {
+		counts.add(after)
+	}
+}
+
+// The totals and the host and format groups of a result, creating the
+// groups as needed. Called with mu held.
+func (s *runSummary) countsFor(result Result) []*summaryCounts {
+	host, format := "unknown", summaryFormat(result)
+	if u, err := url.Parse(result.URL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	all := []*summaryCounts{&s.summaryCounts}
+	for _, group := range []struct {
+		counts map[string]*summaryCounts
+		key    string
+	}{{s.ByHost, host}, {s.ByFormat, format}} {
+		counts, ok := group.counts[group.key]
+		if !ok {
+			counts = &summaryCounts{}
+			group.counts[group.key] = counts
+		}
+		all = append(all, counts)
+	}
+	return all
+}
+
+// Format of an image for the summary: the extension of the saved file, or
+// of the URL path if nothing was saved
+func summaryFormat(result Result) string {
+	ext := path.Ext(result.Path)
+	if ext == "" {
+		if u, err := url.Parse(result.URL); err == nil {
+			ext = path.Ext(u.Path)
+		}
+	}
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	switch ext {
+	case "":
+		return "unknown"
+	case "jpeg":
+		return "jpg"
+	}
+	return ext
+}
+
+// Write the summary as indented JSON
+func (s *runSummary) writeFile(filePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Finished = time.Now()
+	s.DurationSeconds = s.Finished.Sub(s.Started).Seconds()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, append(data, '\n'), 0644)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// Reader limiting the average read rate to bytesPerSec
+type throttledReader struct {
+	r           io.Reader
+	bytesPerSec int64
+	start       time.Time
+	read        int64
+}
+
+func newThrottledReader(r io.Reader, bytesPerSec int64) *throttledReader {
+	return &throttledReader{r: r, bytesPerSec: bytesPerSec, start: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Read in slices of about a tenth of a second so the rate stays smooth
+	if chunk := max(t.bytesPerSec/10, 1); int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+
+	// Sleep until the average rate is back under the limit
+	expected := time.Duration(float64(t.read) / float64(t.bytesPerSec) * float64(time.Second))
+	if wait := expected - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
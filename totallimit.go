@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Recompress the largest images until the total size fits within totalLimitMB.
+// Only formats in compressFormats are recompressed (nil = all). Returns the
+// paths with renamed files (PNG/GIF re-encoded as JPEG) updated.
+func fitTotalLimit(paths []string, totalLimitMB float64, jpegQuality int, postSharpen bool, compressFormats map[string]bool, modes fileModes) []string {
+	limitBytes := int64(totalLimitMB * 1024 * 1024)
+
+	type imageFile struct {
+		path string
+		size int64
+	}
+
+	// Collect sizes, ignoring duplicates and missing files
+	var files []imageFile
+	var total int64
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, imageFile{path: path, size: info.Size()})
+		total += info.Size()
+	}
+
+	fmt.Printf("\nTotal size: %.2fMB (limit %.2fMB)\n", float64(total)/1024/1024, totalLimitMB)
+	if total <= limitBytes {
+		return paths
+	}
+
+	// Largest images first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].size > files[j].size
+	})
+
+	fmt.Println("Total size exceeds limit, recompressing largest images...")
+	var recompressed []string
+	renamed := make(map[string]string)
+	for _, file := range files {
+		if total <= limitBytes {
+			break
+		}
+
+		// Shrink this image by the remaining excess, but never below a quarter
+		// of its size so one image doesn't absorb the whole budget
+		excess := total - limitBytes
+		target := file.size - excess
+		if target < file.size/4 {
+			target = file.size / 4
+		}
+
+		data, err := os.ReadFile(file.path)
+		if err != nil {
+			continue
+		}
+		if compressFormats != nil && !compressFormats[imageFormat(data, file.path)] {
+			continue
+		}
+
+		fmt.Printf("Recompressing: %s\n", filepath.Base(file.path))
+		compressed, err := compressImage(data, float64(target)/1024/1024, jpegQuality, postSharpen)
+		if err != nil {
+			warnf("  Warning: skipping, %v", err)
+			continue
+		}
+		if int64(len(compressed)) >= file.size {
+			continue
+		}
+
+		// Compression re-encodes PNG and GIF as JPEG, without replacing
+		// another image that already has the .jpg name
+		newPath := file.path
+		if isRenamedToJPEG(file.path) {
+			if newPath, err = jpegPath(file.path, compressed); err != nil {
+				warnf("  Warning: skipping, %v", err)
+				continue
+			}
+		}
+
+		if err := modes.writeFile(newPath, compressed); err != nil {
+			warnf("  Warning: failed to write file: %v", err)
+			continue
+		}
+		if newPath != file.path {
+			os.Remove(file.path)
+			renamed[file.path] = newPath
+		}
+
+		total -= file.size - int64(len(compressed))
+		recompressed = append(recompressed, fmt.Sprintf("%s (%.2fMB -> %.2fMB)",
+			filepath.Base(newPath),
+			float64(file.size)/1024/1024,
+			float64(len(compressed))/1024/1024))
+	}
+
+	fmt.Printf("Final total size: %.2fMB\n", float64(total)/1024/1024)
+	if len(recompressed) > 0 {
+		fmt.Printf("Recompressed %d images:\n", len(recompressed))
+		for _, line := range recompressed {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	if total > limitBytes {
+		warnf("Warning: could not fit all images within the total limit")
+	}
+
+	updated := make([]string, len(paths))
+	for i, path := range paths {
+		if newPath, ok := renamed[path]; ok {
+			path = newPath
+		}
+		updated[i] = path
+	}
+	return updated
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A PNG recompressed to fit the total limit doesn't replace a .jpg of the
+// same name
+func TestFitTotalLimitKeepsExistingJPEG(t *testing.T) {
+	dir := t.TempDir()
+	existing := []byte("a different image")
+	jpgPath := filepath.Join(dir, "photo.jpg")
+	pngPath := filepath.Join(dir, "photo.png")
+	writeTestFile(t, jpgPath, existing)
+	writeTestFile(t, pngPath, noisyPNG(t, 200))
+
+	updated := fitTotalLimit([]string{jpgPath, pngPath}, 0.05, 0, false, nil, fileModes{})
+
+	data, err := os.ReadFile(jpgPath)
+	if err != nil || !bytes.Equal(data, existing) {
+		t.Errorf("photo.jpg was overwritten")
+	}
+	if len(updated) != 2 || updated[0] != jpgPath || !strings.HasPrefix(filepath.Base(updated[1]), "photo-") {
+		t.Fatalf("updated paths = %v, want photo.jpg and photo-<hash>.jpg", updated)
+	}
+	if _, err := os.Stat(updated[1]); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(pngPath); !os.IsNotExist(err) {
+		t.Errorf("photo.png still exists")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+)
+
+// RoundTripper printing the DNS, connect, TLS and time-to-first-byte
+// durations of every request, including redirects and retries
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func newTracingTransport(base http.RoundTripper) *tracingTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tracingTransport{base: base}
+}
+
+// Timings of a single request
+type requestTiming struct {
+	start               time.Time
+	dnsStart, dnsDone   time.Time
+	connStart, connDone time.Time
+	tlsStart, tlsDone   time.Time
+	firstByte           time.Time
+	reused              bool
+	remoteAddr          string
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timing := &requestTiming{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { timing.dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { timing.dnsDone = time.Now() },
+		ConnectStart:      func(string, string) { timing.connStart = time.Now() },
+		ConnectDone:       func(string, string, error) { timing.connDone = time.Now() },
+		TLSHandshakeStart: func() { timing.tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { timing.tlsDone = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			timing.reused = info.Reused
+			if info.Conn != nil {
+				timing.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		GotFirstResponseByte: func() { timing.firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := t.base.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = resp.Status
+	}
+	fmt.Fprintf(statusOut, "  Trace: %s %s -> %s\n    %s\n", req.Method, req.URL.Redacted(), status, timing.summary())
+	return resp, err
+}
+
+// One-line summary of the phases that happened
+func (t *requestTiming) summary() string {
+	var parts []string
+	phase := func(name string, start, done time.Time) {
+		if !start.IsZero() && !done.IsZero() {
+			parts = append(parts, fmt.Sprintf("%s %s", name, formatDuration(done.Sub(start))))
+		}
+	}
+	phase("dns", t.dnsStart, t.dnsDone)
+	phase("connect", t.connStart, t.connDone)
+	phase("tls", t.tlsStart, t.tlsDone)
+	phase("first byte", t.start, t.firstByte)
+	if t.reused {
+		parts = append(parts, "reused connection")
+	}
+	if t.remoteAddr != "" {
+		parts = append(parts, "to "+t.remoteAddr)
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("failed after %s", formatDuration(time.Since(t.start)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(100 * time.Microsecond).String()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Sed-style URL substitution given to -transform-url, e.g. "s/thumb/full/"
+type urlTransform struct {
+	expr   string
+	re     *regexp.Regexp
+	repl   string // Replacement in regexp.Expand syntax
+	global bool
+}
+
+// Parse "s<d>pattern<d>replacement<d>[g]" where <d> is any delimiter.
+// The replacement uses sed syntax: & is the whole match and \1-\9 are groups.
+func parseURLTransform(expr string) (urlTransform, error) {
+	if len(expr) < 2 || expr[0] != 's' {
+		return urlTransform{}, fmt.Errorf("invalid transform %q (expected s/pattern/replacement/)", expr)
+	}
+	delim := expr[1]
+
+	// Split on unescaped delimiters
+	var parts []string
+	var cur strings.Builder
+	for i := 2; i < len(expr); i++ {
+		c := expr[i]
+		if c == '\\' && i+1 < len(expr) && expr[i+1] == delim {
+			cur.WriteByte(delim)
+			i++
+			continue
+		}
+		if c == '\\' && i+1 < len(expr) {
+			cur.WriteByte(c)
+			cur.WriteByte(expr[i+1])
+			i++
+			continue
+		}
+		if c == delim {
+			parts = append(parts, cur.String())
+			cur.Reset()
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	if len(parts) != 2 {
+		return urlTransform{}, fmt.Errorf("invalid transform %q (expected s/pattern/replacement/)", expr)
+	}
+
+	flags := cur.String()
+	if flags != "" && flags != "g" {
+		return urlTransform{}, fmt.Errorf("invalid transform flags %q in %q (only g is supported)", flags, expr)
+	}
+
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return urlTransform{}, fmt.Errorf("invalid transform pattern %q: %v", parts[0], err)
+	}
+
+	return urlTransform{expr: expr, re: re, repl: sedReplacement(parts[1]), global: flags == "g"}, nil
+}
+
+// Convert a sed replacement to regexp.Expand syntax
+func sedReplacement(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			fmt.Fprintf(&b, "${%c}", s[i+1])
+			i++
+		case c == '\\' && i+1 < len(s):
+			if s[i+1] == '$' {
+				b.WriteString("$$")
+			} else {
+				b.WriteByte(s[i+1])
+			}
+			i++
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Apply the substitution to a URL, replacing only the first match unless
+// the g flag was given
+func (t urlTransform) apply(u string) string {
+	if t.global {
+		return t.re.ReplaceAllString(u, t.repl)
+	}
+	loc := t.re.FindStringSubmatchIndex(u)
+	if loc == nil {
+		return u
+	}
+	out := t.re.ExpandString(nil, t.repl, u, loc)
+	return u[:loc[0]] + string(out) + u[loc[1]:]
+}
+
+// Value of the repeatable -transform-url flag
+type transformFlag []urlTransform
+
+func (f *transformFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	exprs := make([]string, len(*f))
+	for i, t := range *f {
+		exprs[i] = t.expr
+	}
+	return strings.Join(exprs, ", ")
+}
+
+func (f *transformFlag) Set(value string) error {
+	t, err := parseURLTransform(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, t)
+	return nil
+}
+
+// Apply all transforms in order to each URL, returning how many URLs changed
+func transformURLs(urls []string, transforms []urlTransform) int {
+	changed := 0
+	for i, u := range urls {
+		orig := u
+		for _, t := range transforms {
+			u = t.apply(u)
+		}
+		if u != orig {
+			urls[i] = u
+			changed++
+		}
+	}
+	return changed
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Read image URLs from a plain text file with one URL per line, the format
+// -list-only writes. Blank lines and lines starting with # are skipped.
+// The links are deduplicated and transformed like extracted ones.
+func readURLList(path string, eopts ExtractOptions) (extractedURLs, error) {
+	data, err := readInputFile(path)
+	if err != nil {
+		return extractedURLs{}, fmt.Errorf("failed to read URL list: %v", err)
+	}
+
+	var imageURLs []string
+	invalid := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if u, err := url.Parse(line); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid++
+			continue
+		}
+		imageURLs = append(imageURLs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return extractedURLs{}, fmt.Errorf("failed to read URL list: %v", err)
+	}
+	if invalid > 0 {
+		warnf("Warning: skipped %d lines that aren't http(s) URLs", invalid)
+	}
+
+	name := inputName(path)
+	if len(imageURLs) == 0 {
+		fmt.Fprintln(statusOut, "No image links found")
+		return extractedURLs{Input: name}, nil
+	}
+
+	fmt.Fprintf(statusOut, "Found %d image links\n", len(imageURLs))
+	return extractedURLs{URLs: eopts.cleanURLs(imageURLs, nil, nil), Found: len(imageURLs), Input: name}, nil
+}
+
+// Links of a top-level JSON array whose elements are all http(s) URL
+// strings, a common export shape. Each element is taken as an image link
+// without the matching heuristics. Returns false for any other shape, and
+// when filter has -where, -field, -name-field or -group-by conditions, which need
+// objects. Strings longer than the filter's maximum are left out.
+func urlArrayLinks(data interface{}, filter *urlFilter) ([]string, bool) {
+	items, ok := data.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+	if filter != nil && (len(filter.where) > 0 || filter.fields != nil || filter.nameField != "" || filter.groupField != "") {
+		return nil, false
+	}
+
+	links := make([]string, 0, len(items))
+	tooLong := 0
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		s = strings.TrimSpace(s)
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(s, " \t\n") {
+			return nil, false
+		}
+		if filter != nil && filter.maxURLLength > 0 && len(s) > filter.maxURLLength {
+			tooLong++
+			continue
+		}
+		links = append(links, s)
+	}
+	if tooLong > 0 {
+		filter.tooLong.Add(int64(tooLong))
+	}
+	return links, true
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+)
+
+// Check downloaded data for -strict-validate. JPEG, PNG and GIF images are
+// fully decoded, so truncated files that still have a valid header are
+// rejected as corrupt. BMP, WebP and SVG can't be decoded with the standard
+// library and are only checked for their signature, SVG for an <svg> tag
+// within the first sniffBytes bytes.
+func validateImage(data []byte, sniffBytes int) error {
+	switch sniffExtension(data) {
+	case ".jpg", ".png", ".gif":
+		if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("%w: %v", errCorruptImage, err)
+		}
+		return nil
+	case ".bmp", ".webp":
+		return nil
+	}
+
+	// SVG is sniffed as XML or plain text
+	if looksLikeImage(data[:min(len(data), sniffBytes)]) {
+		return nil
+	}
+	return errNotImage
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"path"
+	"strings"
+)
+
+// Variants -emit-variants can write next to each saved image
+const (
+	variantOriginal  = "original"  // The response exactly as downloaded
+	variantThumbnail = "thumbnail" // A JPEG scaled to fit thumbnailSize
+)
+
+// Longest side of thumbnail variants in pixels
+const thumbnailSize = 256
+
+// Parse the comma-separated -emit-variants list. Besides original and
+// thumbnail, the -convert-to formats are accepted. WebP is rejected since
+// the standard library can only decode it.
+func parseVariants(value string) ([]string, error) {
+	var variants []string
+	seen := make(map[string]bool)
+	for _, name := range splitList(value) {
+		name = strings.ToLower(name)
+		if name == "jpeg" {
+			name = "jpg"
+		}
+		switch {
+		case name == "webp":
+			return nil, fmt.Errorf("webp variants aren't supported: Go's standard library has no WebP encoder")
+		case name == variantOriginal, name == variantThumbnail:
+		case convertFormats[name] != "":
+		default:
+			return nil, fmt.Errorf("unknown variant %q (expected original, thumbnail, jpg, png or gif)", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			variants = append(variants, name)
+		}
+	}
+	return variants, nil
+}
+
+// Write the variants of a downloaded image to subdirectories named after
+// them, mirroring outputPath. Returns the locations written, keyed by
+// variant; failed variants are left out with a warning.
+func writeVariants(sink Sink, outputPath string, data []byte, opts Options) map[string]string {
+	locations := make(map[string]string)
+	stem := strings.TrimSuffix(outputPath, path.Ext(outputPath))
+	for _, variant := range opts.Variants {
+		variantData, variantPath, err := renderVariant(variant, data, outputPath, stem, opts.JPEGQuality)
+		if err != nil {
+			warnf("  Warning: failed to create %s variant: %v", variant, err)
+			continue
+		}
+		variantPath = path.Join(variant, variantPath)
+		if err := sink.Write(variantPath, variantData); err != nil {
+			warnf("  Warning: failed to write %s variant: %v", variant, err)
+			continue
+		}
+		locations[variant] = sink.Location(variantPath)
+	}
+	return locations
+}
+
+// Encode one variant, returning its data and path relative to its
+// subdirectory
+func renderVariant(variant string, data []byte, outputPath, stem string, quality int) ([]byte, string, error) {
+	switch variant {
+	case variantOriginal:
+		return data, outputPath, nil
+	case variantThumbnail:
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode image: %v", err)
+		}
+		w, h := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), thumbnailSize, thumbnailSize)
+		if img.Bounds().Dx() > w {
+			img = resizeImage(img, w, h)
+		}
+		if hasTransparency(img) {
+			img = flattenOnWhite(img)
+		}
+		if quality <= 0 {
+			quality = defaultConvertQuality
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), stem + ".jpg", nil
+	default:
+		ext := convertFormats[variant]
+		converted, err := convertImage(data, ext, quality)
+		if err != nil {
+			return nil, "", err
+		}
+		return converted, stem + ext, nil
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, injected with -ldflags, e.g.
+// go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%d)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// Print the version, build information and supported formats
+func printVersion() {
+	rev, date := commit, buildDate
+
+	// Builds from a git checkout record the revision without -ldflags
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+				if len(rev) > 12 {
+					rev = rev[:12]
+				}
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+
+	fmt.Printf("json-shake %s\n", version)
+	fmt.Printf("Commit: %s\n", rev)
+	fmt.Printf("Built: %s\n", date)
+	fmt.Printf("Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Println()
+	printFormats()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Poll files every interval and call onChange for a file once it has stopped
+// changing for one interval, so a file being rewritten in several steps is
+// only processed once. Returns when a value arrives on stop.
+func watchFiles(paths []string, interval time.Duration, stop <-chan os.Signal, onChange func(path string)) {
+	type fileState struct {
+		modTime time.Time
+		size    int64
+	}
+	stat := func(path string) fileState {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fileState{}
+		}
+		return fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	states := make(map[string]fileState)
+	for _, path := range paths {
+		states[path] = stat(path)
+	}
+	pending := make(map[string]bool) // Changed at the previous poll
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, path := range paths {
+			state := stat(path)
+			if state != states[path] {
+				// Still changing, wait for the next poll
+				states[path] = state
+				pending[path] = true
+				continue
+			}
+			if pending[path] {
+				delete(pending, path)
+				if state != (fileState{}) {
+					onChange(path)
+				}
+			}
+		}
+	}
+}
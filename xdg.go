@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Downloads folder configured in the XDG user-dirs file, which desktop
+// environments use for localized or relocated folders. Returns "" when it
+// isn't configured.
+func xdgDownloadDir(homeDir string) string {
+	return readXDGDownloadDir(userDirsPath(homeDir), homeDir)
+}
+
+// Path of the XDG user-dirs file
+func userDirsPath(homeDir string) string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		configDir = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configDir, "user-dirs.dirs")
+}
+
+// Read the Downloads folder from the user-dirs file at path
+func readXDGDownloadDir(path, homeDir string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || key != "XDG_DOWNLOAD_DIR" {
+			continue
+		}
+		return parseXDGDir(value, homeDir)
+	}
+	return ""
+}
+
+// Parse a user-dirs value, which is either "$HOME/relative" or an absolute
+// path in double quotes. A value pointing at the home directory itself
+// means the folder is disabled.
+func parseXDGDir(value, homeDir string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return ""
+	}
+	value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+
+	var dir string
+	switch {
+	case value == "$HOME" || value == "$HOME/":
+		return ""
+	case strings.HasPrefix(value, "$HOME/"):
+		dir = filepath.Join(homeDir, value[len("$HOME/"):])
+	case filepath.IsAbs(value):
+		dir = filepath.Clean(value)
+	default:
+		return ""
+	}
+	if dir == filepath.Clean(homeDir) {
+		return ""
+	}
+	return dir
+}
+
+// Warns once about a missing home directory
+var fallbackWarned sync.Once
+
+// Directory used when there's no home directory, as in minimal containers
+// without HOME: the working directory if it's writable, else the temporary
+// directory
+func fallbackDownloadDir(reason error) string {
+	dir, err := os.Getwd()
+	why := "the working directory"
+	if err != nil || !isWritableDir(dir) {
+		dir, why = os.TempDir(), "the temporary directory, since the working directory isn't writable"
+	}
+	fallbackWarned.Do(func() {
+		warnf("Warning: no home directory (%v), saving to %s instead: %s", reason, why, dir)
+	})
+	return dir
+}
+
+// Check whether files can be created in dir
+func isWritableDir(dir string) bool {
+	file, err := os.CreateTemp(dir, ".json-shake-*")
+	if err != nil {
+		return false
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadXDGDownloadDir(t *testing.T) {
+	home := "/home/user"
+	tests := []struct {
+		name     string
+		userDirs string // Content of user-dirs.dirs, "" for no file
+		want     string
+	}{
+		{"no file", "", ""},
+		{"relative to home", "XDG_DESKTOP_DIR=\"$HOME/Desktop\"\nXDG_DOWNLOAD_DIR=\"$HOME/Downloads\"\n", "/home/user/Downloads"},
+		{"localized", "# Written by xdg-user-dirs-update\nXDG_DOWNLOAD_DIR=\"$HOME/Téléchargements\"\n", "/home/user/Téléchargements"},
+		{"absolute", "XDG_DOWNLOAD_DIR=\"/data/downloads/\"\n", "/data/downloads"},
+		{"indented", "  XDG_DOWNLOAD_DIR=\"$HOME/dl\"  \n", "/home/user/dl"},
+		{"escaped quote", "XDG_DOWNLOAD_DIR=\"$HOME/my \\\"files\\\"\"\n", "/home/user/my \"files\""},
+		{"disabled", "XDG_DOWNLOAD_DIR=\"$HOME/\"\n", ""},
+		{"home itself", "XDG_DOWNLOAD_DIR=\"/home/user\"\n", ""},
+		{"unquoted", "XDG_DOWNLOAD_DIR=$HOME/Downloads\n", ""},
+		{"relative", "XDG_DOWNLOAD_DIR=\"Downloads\"\n", ""},
+		{"not set", "XDG_MUSIC_DIR=\"$HOME/Music\"\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "user-dirs.dirs")
+			if tt.userDirs != "" {
+				if err := os.WriteFile(path, []byte(tt.userDirs), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := readXDGDownloadDir(path, home); got != tt.want {
+				t.Errorf("readXDGDownloadDir = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserDirsPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/etc/xdg-test")
+	if got := userDirsPath("/home/user"); got != "/etc/xdg-test/user-dirs.dirs" {
+		t.Errorf("userDirsPath with XDG_CONFIG_HOME = %q", got)
+	}
+	t.Setenv("XDG_CONFIG_HOME", "")
+	if got := userDirsPath("/home/user"); got != "/home/user/.config/user-dirs.dirs" {
+		t.Errorf("userDirsPath = %q", got)
+	}
+}